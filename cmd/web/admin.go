@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/vishal-rfx/snippetbox/internal/models"
+	"github.com/vishal-rfx/snippetbox/internal/validator"
+)
+
+// adminUsers lists the users matching the search query in the q parameter, or the most recent users if
+// there is no query.
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+
+	users, err := app.users.Search(r.Context(), query)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = adminSearchForm{Query: query}
+	data.Users = users
+	data.Roles = models.Roles
+
+	app.render(w, r, http.StatusOK, "admin.tmpl.html", data)
+}
+
+type adminSearchForm struct {
+	Query string
+}
+
+type adminUserRoleForm struct {
+	Role models.Role `form:"role"`
+}
+
+func (app *application) adminUserRolePost(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.adminTargetUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var form adminUserRoleForm
+	err := app.decodePostForm(r, &form)
+	if err != nil || !validator.PermittedValue(form.Role, models.Roles...) {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err = app.users.SetRole(r.Context(), id, form.Role)
+	if err != nil {
+		app.adminUserActionError(w, r, err)
+		return
+	}
+
+	app.adminUserActionDone(w, r, fmt.Sprintf("User #%d is now a %s", id, form.Role))
+}
+
+// adminUserDisablePost disables a user's account and immediately logs them out everywhere.
+func (app *application) adminUserDisablePost(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.adminTargetUserID(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.users.SetDisabled(r.Context(), id, true)
+	if err != nil {
+		app.adminUserActionError(w, r, err)
+		return
+	}
+
+	err = app.revokeAllSessions(r.Context(), id, "")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.rememberTokens.DeleteAllForUser(r.Context(), id, "")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.adminUserActionDone(w, r, fmt.Sprintf("User #%d has been disabled", id))
+}
+
+func (app *application) adminUserEnablePost(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.adminTargetUserID(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.users.SetDisabled(r.Context(), id, false)
+	if err != nil {
+		app.adminUserActionError(w, r, err)
+		return
+	}
+
+	app.adminUserActionDone(w, r, fmt.Sprintf("User #%d has been enabled", id))
+}
+
+func (app *application) adminUserPasswordResetPost(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.adminTargetUserID(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.users.RequirePasswordReset(r.Context(), id)
+	if errors.Is(err, models.ErrExternalUser) {
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("User #%d logs in through an external provider, so they have no password to reset", id))
+		http.Redirect(w, r, app.url("/admin"), http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.adminUserActionError(w, r, err)
+		return
+	}
+
+	app.adminUserActionDone(w, r, fmt.Sprintf("User #%d must change their password", id))
+}
+
+// adminTargetUserID reads the ID of the user an admin action applies to from the URL path. Admins can't
+// act on their own account, so that they can't accidentally lock themselves out. If the ID is invalid then
+// a response is sent and ok is false.
+func (app *application) adminTargetUserID(w http.ResponseWriter, r *http.Request) (id int, ok bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return 0, false
+	}
+
+	if id == app.authenticatedUser(r).ID {
+		app.sessionManager.Put(r.Context(), "flash", "You can't change your own account from the admin console")
+		http.Redirect(w, r, app.url("/admin"), http.StatusSeeOther)
+		return 0, false
+	}
+
+	return id, true
+}
+
+func (app *application) adminUserActionError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, models.ErrNoRecord) {
+		http.NotFound(w, r)
+	} else {
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) adminUserActionDone(w http.ResponseWriter, r *http.Request, message string) {
+	app.logger.InfoContext(r.Context(), "Admin action", "path", r.URL.Path, "by", app.authenticatedUser(r).ID)
+
+	app.sessionManager.Put(r.Context(), "flash", message)
+	http.Redirect(w, r, app.url("/admin"), http.StatusSeeOther)
+}
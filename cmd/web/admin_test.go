@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+func TestAdminRequiresRole(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+
+	code, _, _ := ts.get(t, "/admin")
+	assert.Equal(t, code, http.StatusForbidden)
+
+	_, _, body := ts.get(t, "/snippet/view/1")
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, _, _ = ts.postForm(t, "/snippet/delete/1", form)
+	assert.Equal(t, code, http.StatusForbidden)
+}
+
+func TestAdminActions(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.loginAs(t, "admin@example.com")
+
+	code, _, body := ts.get(t, "/admin")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "alice@example.com")
+	assert.StringContains(t, body, `/admin/users/1/password-reset"`)
+	csrfToken := extractCSRFToken(t, body)
+
+	// External users have no password, so there's no button to force them to reset it.
+	assert.Equal(t, strings.Contains(body, `/admin/users/4/password-reset"`), false)
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		role         string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Disable user",
+			urlPath:      "/admin/users/1/disable",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/admin",
+		},
+		{
+			name:         "Enable user",
+			urlPath:      "/admin/users/1/enable",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/admin",
+		},
+		{
+			name:         "Force password reset",
+			urlPath:      "/admin/users/1/password-reset",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/admin",
+		},
+		{
+			name:         "Change role",
+			urlPath:      "/admin/users/1/role",
+			role:         "moderator",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/admin",
+		},
+		{
+			name:     "Invalid role",
+			urlPath:  "/admin/users/1/role",
+			role:     "superuser",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "Non-existent user",
+			urlPath:  "/admin/users/2/disable",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:         "Own account",
+			urlPath:      "/admin/users/3/disable",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/admin",
+		},
+		{
+			name:         "Delete snippet",
+			urlPath:      "/snippet/delete/1",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/",
+		},
+		{
+			name:     "Delete non-existent snippet",
+			urlPath:  "/snippet/delete/2",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+			if tt.role != "" {
+				form.Add("role", tt.role)
+			}
+
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+		})
+	}
+}
+
+func TestAdminPasswordResetExternalUser(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.loginAs(t, "admin@example.com")
+
+	_, _, body := ts.get(t, "/admin")
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, header, _ := ts.postForm(t, "/admin/users/4/password-reset", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/admin")
+
+	_, _, body = ts.get(t, "/admin")
+	assert.StringContains(t, body, "User #4 logs in through an external provider, so they have no password to reset")
+}
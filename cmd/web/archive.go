@@ -0,0 +1,272 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vishal-rfx/snippetbox/internal/models"
+)
+
+// archiveContentTypes maps the archive formats which snippets can be downloaded in to their content types.
+var archiveContentTypes = map[string]string{
+	".zip":    "application/zip",
+	".tar.gz": "application/gzip",
+}
+
+// parseArchiveName splits an archive filename like "12.tar.gz" into its name ("12") and format (".tar.gz").
+// It returns false if the format isn't one we support.
+func parseArchiveName(filename string) (string, string, bool) {
+	for format := range archiveContentTypes {
+		if name, ok := strings.CutSuffix(filename, format); ok && name != "" {
+			return name, format, true
+		}
+	}
+	return "", "", false
+}
+
+// archiveWriter writes files to a zip or tar.gz archive. Both formats write each file as it's added, so
+// the archive can be streamed to the client without holding all of it in memory.
+type archiveWriter interface {
+	WriteFile(name string, modTime time.Time, content []byte) error
+	Close() error
+}
+
+// newArchiveWriter returns an archiveWriter for the given format, which writes to w.
+func newArchiveWriter(w io.Writer, format string) archiveWriter {
+	if format == ".tar.gz" {
+		gz := gzip.NewWriter(w)
+		return &tarGzArchive{gz: gz, tw: tar.NewWriter(gz)}
+	}
+	return &zipArchive{zw: zip.NewWriter(w)}
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+func (a *zipArchive) WriteFile(name string, modTime time.Time, content []byte) error {
+	fw, err := a.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime})
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(content)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+type tarGzArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (a *tarGzArchive) WriteFile(name string, modTime time.Time, content []byte) error {
+	err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(content)),
+		ModTime:  modTime,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = a.tw.Write(content)
+	return err
+}
+
+func (a *tarGzArchive) Close() error {
+	err := a.tw.Close()
+	if err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// archiveManifest is written to manifest.json at the end of each archive. It describes the snippets in the
+// archive, including the details which aren't part of the files themselves, so that they can be imported
+// somewhere else.
+type archiveManifest struct {
+	Exported time.Time         `json:"exported"`
+	Snippets []archivedSnippet `json:"snippets"`
+}
+
+type archivedSnippet struct {
+	ID      int            `json:"id"`
+	Title   string         `json:"title"`
+	Created time.Time      `json:"created"`
+	Expires time.Time      `json:"expires"`
+	Tags    []string       `json:"tags"`
+	Files   []archivedFile `json:"files"`
+}
+
+type archivedFile struct {
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Path     string `json:"path"`
+}
+
+// writeSnippet adds the files in a snippet to an archive, in a directory named after the snippet's ID, and
+// returns its entry for the manifest.
+func writeSnippet(a archiveWriter, s models.Snippet) (archivedSnippet, error) {
+	entry := archivedSnippet{
+		ID:      s.ID,
+		Title:   s.Title,
+		Created: s.Created,
+		Expires: s.Expires,
+		Tags:    s.Tags,
+	}
+	if entry.Tags == nil {
+		entry.Tags = []string{}
+	}
+
+	for _, f := range s.Files {
+		path := fmt.Sprintf("snippet-%d/%s", s.ID, f.Name)
+		err := a.WriteFile(path, s.Created, []byte(f.Content))
+		if err != nil {
+			return archivedSnippet{}, err
+		}
+		entry.Files = append(entry.Files, archivedFile{Name: f.Name, Language: f.Language, Path: path})
+	}
+
+	return entry, nil
+}
+
+// writeManifest adds the manifest to an archive, and closes it.
+func writeManifest(a archiveWriter, manifest archiveManifest) error {
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = a.WriteFile("manifest.json", manifest.Exported, b)
+	if err != nil {
+		return err
+	}
+
+	return a.Close()
+}
+
+// setArchiveHeaders sets the headers for downloading an archive with the given filename.
+func setArchiveHeaders(w http.ResponseWriter, filename, format string) {
+	w.Header().Set("Content-Type", archiveContentTypes[format])
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename + format}))
+}
+
+// abortArchive is called when writing an archive fails part way through. By then the response headers have
+// been sent, so we can't send an error response. Instead we log the error and abort the response, so that
+// the client sees the download fail rather than getting an archive with files missing.
+func (app *application) abortArchive(r *http.Request, err error) {
+	app.logger.ErrorContext(r.Context(), "writing archive", "error", err.Error())
+	panic(http.ErrAbortHandler)
+}
+
+// extendWriteDeadline replaces the server's write timeout, which is too short for big archives, with the
+// archive write timeout. A zero timeout removes the deadline altogether.
+func (app *application) extendWriteDeadline(w http.ResponseWriter, r *http.Request) {
+	var deadline time.Time
+	if app.archiveWriteTimeout > 0 {
+		deadline = time.Now().Add(app.archiveWriteTimeout)
+	}
+
+	err := http.NewResponseController(w).SetWriteDeadline(deadline)
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		app.logger.WarnContext(r.Context(), "extending write deadline", "error", err.Error())
+	}
+}
+
+// snippetArchive sends the files in a snippet as a zip or tar.gz archive, depending on the extension in the
+// URL, like /snippet/archive/12.zip.
+func (app *application) snippetArchive(w http.ResponseWriter, r *http.Request) {
+	name, format, ok := parseArchiveName(r.PathValue("archive"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	id, err := strconv.Atoi(name)
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	snippet, err := app.snippets.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.extendWriteDeadline(w, r)
+	setArchiveHeaders(w, fmt.Sprintf("snippet-%d", id), format)
+
+	a := newArchiveWriter(w, format)
+	entry, err := writeSnippet(a, snippet)
+	if err != nil {
+		app.abortArchive(r, err)
+	}
+
+	err = writeManifest(a, archiveManifest{Exported: time.Now().UTC(), Snippets: []archivedSnippet{entry}})
+	if err != nil {
+		app.abortArchive(r, err)
+	}
+}
+
+// accountExport sends all of the user's unexpired snippets as a single zip or tar.gz archive, for backing
+// them up or moving them somewhere else. The snippets are fetched and written one at a time, so exporting
+// a lot of snippets doesn't need a lot of memory.
+func (app *application) accountExport(w http.ResponseWriter, r *http.Request) {
+	_, format, ok := parseArchiveName(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	ids, err := app.snippets.UserSnippetIDs(r.Context(), app.authenticatedUser(r).ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.extendWriteDeadline(w, r)
+	setArchiveHeaders(w, "snippetbox-export-"+time.Now().UTC().Format("20060102"), format)
+
+	a := newArchiveWriter(w, format)
+	manifest := archiveManifest{Exported: time.Now().UTC(), Snippets: []archivedSnippet{}}
+	for _, id := range ids {
+		snippet, err := app.snippets.Get(r.Context(), id)
+		if err != nil {
+			// The snippet may have expired or been deleted since we got the list.
+			if errors.Is(err, models.ErrNoRecord) {
+				continue
+			}
+			app.abortArchive(r, err)
+		}
+
+		entry, err := writeSnippet(a, snippet)
+		if err != nil {
+			app.abortArchive(r, err)
+		}
+		manifest.Snippets = append(manifest.Snippets, entry)
+	}
+
+	err = writeManifest(a, manifest)
+	if err != nil {
+		app.abortArchive(r, err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+// getArchive downloads an archive from the test server and returns the files in it, by name.
+func (ts *testServer) getArchive(t *testing.T, urlPath string) (int, http.Header, map[string]string) {
+	rs, err := ts.Client().Get(ts.URL + urlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.StatusCode != http.StatusOK {
+		return rs.StatusCode, rs.Header, nil
+	}
+
+	files := map[string]string{}
+	switch rs.Header.Get("Content-Type") {
+	case "application/zip":
+		zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[f.Name] = string(b)
+		}
+
+	case "application/gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[hdr.Name] = string(b)
+		}
+
+	default:
+		t.Fatalf("unexpected content type %q", rs.Header.Get("Content-Type"))
+	}
+
+	return rs.StatusCode, rs.Header, files
+}
+
+func TestSnippetArchive(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	for _, format := range []string{".zip", ".tar.gz"} {
+		t.Run(format, func(t *testing.T) {
+			code, header, files := ts.getArchive(t, "/snippet/archive/1"+format)
+			assert.Equal(t, code, http.StatusOK)
+			assert.Equal(t, header.Get("Content-Disposition"), "attachment; filename=snippet-1"+format)
+			assert.Equal(t, len(files), 3)
+			assert.Equal(t, files["snippet-1/pond.txt"], "An old silent pond...")
+			assert.Equal(t, files["snippet-1/frog.md"], "A frog jumps into the pond,")
+
+			var manifest archiveManifest
+			err := json.Unmarshal([]byte(files["manifest.json"]), &manifest)
+			assert.NilError(t, err)
+			assert.Equal(t, len(manifest.Snippets), 1)
+			assert.Equal(t, manifest.Snippets[0].Title, "An old silent pond")
+			assert.Equal(t, manifest.Snippets[0].Files[1].Path, "snippet-1/frog.md")
+		})
+	}
+
+	for _, urlPath := range []string{"/snippet/archive/2.zip", "/snippet/archive/1.rar", "/snippet/archive/.zip", "/snippet/archive/foo.zip"} {
+		code, _, _ := ts.getArchive(t, urlPath)
+		assert.Equal(t, code, http.StatusNotFound)
+	}
+}
+
+func TestAccountExport(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The export needs the user to be logged in.
+	code, _, _ := ts.getArchive(t, "/account/export.zip")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	ts.login(t)
+	for _, format := range []string{".zip", ".tar.gz"} {
+		t.Run(format, func(t *testing.T) {
+			code, _, files := ts.getArchive(t, "/account/export"+format)
+			assert.Equal(t, code, http.StatusOK)
+			assert.Equal(t, files["snippet-1/pond.txt"], "An old silent pond...")
+
+			var manifest archiveManifest
+			err := json.Unmarshal([]byte(files["manifest.json"]), &manifest)
+			assert.NilError(t, err)
+			assert.Equal(t, len(manifest.Snippets), 1)
+			assert.Equal(t, manifest.Snippets[0].ID, 1)
+		})
+	}
+}
+
+// deadlineRecorder is a httptest.ResponseRecorder which records the write deadlines set through
+// http.ResponseController.
+type deadlineRecorder struct {
+	*httptest.ResponseRecorder
+	deadlines []time.Time
+}
+
+func (dr *deadlineRecorder) SetWriteDeadline(deadline time.Time) error {
+	dr.deadlines = append(dr.deadlines, deadline)
+	return nil
+}
+
+func TestArchiveWriteDeadline(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+	}{
+		{name: "Extended", timeout: 5 * time.Minute},
+		{name: "Disabled", timeout: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.archiveWriteTimeout = tt.timeout
+
+			// The request goes through all of the middleware, including response compression, to check that
+			// the deadline reaches the underlying connection.
+			r := httptest.NewRequest(http.MethodGet, "/snippet/archive/1.zip", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			dr := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+
+			start := time.Now()
+			app.routes().ServeHTTP(dr, r)
+
+			assert.Equal(t, dr.Code, http.StatusOK)
+			assert.Equal(t, len(dr.deadlines), 1)
+			if tt.timeout == 0 {
+				assert.Equal(t, dr.deadlines[0].IsZero(), true)
+			} else {
+				assert.Equal(t, dr.deadlines[0].Before(start.Add(tt.timeout)), false)
+			}
+		})
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vishal-rfx/snippetbox/internal/models"
+	"github.com/vishal-rfx/snippetbox/internal/validator"
+)
+
+// commentForm holds the fields of the forms for posting and editing comments. ParentID is the comment being
+// replied to, or 0 for a new top level comment. File and Lines are the lines which a new top level comment
+// is about, if any.
+type commentForm struct {
+	Body                string `form:"body"`
+	ParentID            int    `form:"parent_id"`
+	File                string `form:"file"`
+	Lines               string `form:"lines"`
+	validator.Validator `form:"-"`
+}
+
+// check validates the comment body.
+func (form *commentForm) check() {
+	form.CheckField(validator.NotBlank(form.Body), "body", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Body, models.MaxCommentLength), "body", fmt.Sprintf("This field cannot be more than %d characters long", models.MaxCommentLength))
+}
+
+// checkLines validates the lines which a new comment is about, and returns them. Replies are shown with the
+// comment they reply to, so they can't be about lines of their own. The file doesn't have to be chosen for
+// snippets with only one file.
+func (form *commentForm) checkLines(snippet models.Snippet) models.LineRange {
+	if form.ParentID != 0 || (form.File == "" && strings.TrimSpace(form.Lines) == "") {
+		return models.LineRange{}
+	}
+
+	if form.File == "" && len(snippet.Files) == 1 {
+		form.File = snippet.Files[0].Name
+	}
+	i := slices.IndexFunc(snippet.Files, func(f models.SnippetFile) bool { return f.Name == form.File })
+	if i < 0 {
+		form.AddFieldError("lines", "Choose which file these lines are in")
+		return models.LineRange{}
+	}
+
+	lines, ok := models.ParseLineRange(form.Lines)
+	if !ok {
+		form.AddFieldError("lines", "Enter a line number, or a range of lines like 10-20")
+		return models.LineRange{}
+	}
+	if n := len(snippet.Files[i].Lines()); lines.End > n {
+		form.AddFieldError("lines", fmt.Sprintf("%s only goes up to line %d", form.File, n))
+		return models.LineRange{}
+	}
+
+	lines.File = form.File
+	return lines
+}
+
+// commentVisibleTo returns a function which reports whether the user who made the request can see a comment.
+// Everyone can see published comments. Pending comments can also be seen by their author, so that they know
+// their comment was received, and moderators can see everything apart from deleted comments.
+func (app *application) commentVisibleTo(r *http.Request) func(models.Comment) bool {
+	user := app.authenticatedUser(r)
+	isModerator := user.Role.Allows(models.RoleModerator)
+
+	return func(c models.Comment) bool {
+		switch c.Status {
+		case models.CommentPublished:
+			return true
+		case models.CommentPending:
+			return isModerator || (user.ID != 0 && c.UserID == user.ID)
+		case models.CommentHidden:
+			return isModerator
+		default:
+			return false
+		}
+	}
+}
+
+// snippetPageData returns the template data for a snippet's page, with its forks and comments, along with
+// the time the page last changed.
+func (app *application) snippetPageData(r *http.Request, snippet models.Snippet) (templateData, time.Time, error) {
+	forks, err := app.snippets.Forks(r.Context(), snippet.ID)
+	if err != nil {
+		return templateData{}, time.Time{}, err
+	}
+
+	comments, err := app.comments.ForSnippet(r.Context(), snippet.ID)
+	if err != nil {
+		return templateData{}, time.Time{}, err
+	}
+
+	// The page changes when the snippet is forked, and when a comment is posted, edited, moderated or
+	// deleted, all of which update the comment's Updated time. The forks are newest first.
+	lastModified := snippet.Created
+	if len(forks) > 0 && forks[0].Created.After(lastModified) {
+		lastModified = forks[0].Created
+	}
+	for _, c := range comments {
+		if c.Updated.After(lastModified) {
+			lastModified = c.Updated
+		}
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Forks = forks
+	data.Comments, data.LineComments = lineComments(models.ThreadComments(comments, app.commentVisibleTo(r)), snippet.Files)
+	data.Form = commentForm{}
+
+	return data, lastModified, nil
+}
+
+// lineComments takes the threads of comments about lines out of a list of threaded comments, and returns
+// them by file and the number of the last line they're about, so they can be shown after that line. The
+// threads about lines in files which aren't shown are left in the list with the other comments.
+func lineComments(comments []models.Comment, files []models.SnippetFile) ([]models.Comment, map[string]map[int][]models.Comment) {
+	lineCounts := map[string]int{}
+	for _, f := range files {
+		lineCounts[f.Name] = len(f.Lines())
+	}
+
+	var rest []models.Comment
+	byLine := map[string]map[int][]models.Comment{}
+
+	// Replies come straight after the comment they reply to, so they go wherever the top level comment of
+	// their thread went.
+	var lines models.LineRange
+	for _, c := range comments {
+		if c.Depth == 0 {
+			lines = c.Lines
+			if lines.End > lineCounts[lines.File] {
+				lines = models.LineRange{}
+			}
+		}
+
+		if lines.File == "" {
+			rest = append(rest, c)
+			continue
+		}
+		if byLine[lines.File] == nil {
+			byLine[lines.File] = map[int][]models.Comment{}
+		}
+		byLine[lines.File][lines.End] = append(byLine[lines.File][lines.End], c)
+	}
+
+	return rest, byLine
+}
+
+// commentCreatePost posts a new comment, or a reply to an existing one, on a snippet.
+func (app *application) commentCreatePost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	snippet, err := app.snippets.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	var form commentForm
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form.check()
+	lines := form.checkLines(snippet)
+
+	// Replies can only be made to comments on the same snippet which the user can see.
+	if form.ParentID != 0 {
+		parent, err := app.comments.Get(r.Context(), form.ParentID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+		ok := err == nil && parent.SnippetID == snippet.ID && app.commentVisibleTo(r)(parent)
+		form.CheckField(ok, "body", "The comment you're replying to has been removed")
+	}
+
+	user := app.authenticatedUser(r)
+	comment := models.Comment{SnippetID: snippet.ID, UserID: user.ID, ParentID: form.ParentID, Lines: lines, Body: form.Body}
+
+	var status models.CommentStatus
+	if form.Valid() {
+		var reason string
+		status, reason, err = app.moderateComment(r.Context(), comment, user)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		form.CheckField(reason == "", "body", reason)
+	}
+
+	if !form.Valid() {
+		data, _, err := app.snippetPageData(r, snippet)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "view.tmpl.html", data)
+		return
+	}
+
+	commentID, err := app.comments.Insert(r.Context(), snippet.ID, user.ID, form.ParentID, lines, form.Body, status)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if status == models.CommentPending {
+		app.sessionManager.Put(r.Context(), "flash", "Your comment will be shown once a moderator has approved it")
+	} else {
+		app.sessionManager.Put(r.Context(), "flash", "Comment posted!")
+	}
+
+	http.Redirect(w, r, app.url(fmt.Sprintf("/snippet/view/%d#comment-%d", snippet.ID, commentID)), http.StatusSeeOther)
+}
+
+// commentFromRequest looks up the comment with the ID in the request path. If it doesn't exist, or has been
+// deleted, it sends a 404 Not Found response and returns false.
+func (app *application) commentFromRequest(w http.ResponseWriter, r *http.Request) (models.Comment, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return models.Comment{}, false
+	}
+
+	comment, err := app.comments.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return models.Comment{}, false
+	}
+
+	if comment.Status == models.CommentDeleted {
+		http.NotFound(w, r)
+		return models.Comment{}, false
+	}
+
+	return comment, true
+}
+
+// commentEdit shows the form for editing a comment. Only the author can edit their comment, and not once a
+// moderator has hidden it.
+func (app *application) commentEdit(w http.ResponseWriter, r *http.Request) {
+	comment, ok := app.commentFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if comment.UserID != app.authenticatedUser(r).ID || comment.Status == models.CommentHidden {
+		app.clientError(w, r, http.StatusForbidden)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Comment = comment
+	data.Form = commentForm{Body: comment.Body}
+
+	app.render(w, r, http.StatusOK, "comment.tmpl.html", data)
+}
+
+// commentEditPost saves an edited comment. The edit goes through moderation again, so a published comment
+// can go back to pending.
+func (app *application) commentEditPost(w http.ResponseWriter, r *http.Request) {
+	comment, ok := app.commentFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	user := app.authenticatedUser(r)
+	if comment.UserID != user.ID || comment.Status == models.CommentHidden {
+		app.clientError(w, r, http.StatusForbidden)
+		return
+	}
+
+	var form commentForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form.check()
+
+	var status models.CommentStatus
+	if form.Valid() {
+		comment.Body = form.Body
+
+		var reason string
+		status, reason, err = app.moderateComment(r.Context(), comment, user)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		form.CheckField(reason == "", "body", reason)
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Comment = comment
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "comment.tmpl.html", data)
+		return
+	}
+
+	err = app.comments.Update(r.Context(), comment.ID, form.Body, status)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if status == models.CommentPending {
+		app.sessionManager.Put(r.Context(), "flash", "Your comment will be shown once a moderator has approved it")
+	} else {
+		app.sessionManager.Put(r.Context(), "flash", "Comment updated!")
+	}
+
+	http.Redirect(w, r, app.url(fmt.Sprintf("/snippet/view/%d#comment-%d", comment.SnippetID, comment.ID)), http.StatusSeeOther)
+}
+
+// commentDeletePost deletes a comment. Authors can delete their own comments, and moderators can delete
+// anyone's.
+func (app *application) commentDeletePost(w http.ResponseWriter, r *http.Request) {
+	comment, ok := app.commentFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	user := app.authenticatedUser(r)
+	if comment.UserID != user.ID && !user.Role.Allows(models.RoleModerator) {
+		app.clientError(w, r, http.StatusForbidden)
+		return
+	}
+
+	err := app.comments.Delete(r.Context(), comment.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if comment.UserID != user.ID {
+		app.logger.InfoContext(r.Context(), "Comment deleted", "id", comment.ID, "by", user.ID)
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Comment deleted")
+	http.Redirect(w, r, app.url(fmt.Sprintf("/snippet/view/%d#comments", comment.SnippetID)), http.StatusSeeOther)
+}
+
+// commentModeratePost lets moderators approve pending comments and hide comments which shouldn't be shown.
+func (app *application) commentModeratePost(w http.ResponseWriter, r *http.Request) {
+	comment, ok := app.commentFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	var status models.CommentStatus
+	switch r.PostForm.Get("action") {
+	case "approve":
+		status = models.CommentPublished
+	case "hide":
+		status = models.CommentHidden
+	default:
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err = app.comments.SetStatus(r.Context(), comment.ID, status)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.logger.InfoContext(r.Context(), "Comment moderated", "id", comment.ID, "status", status, "by", app.authenticatedUser(r).ID)
+
+	http.Redirect(w, r, app.url(fmt.Sprintf("/snippet/view/%d#comment-%d", comment.SnippetID, comment.ID)), http.StatusSeeOther)
+}
+
+// purgeExpiredComments deletes the comments on expired snippets every interval, until stop is closed.
+// Expired snippets (and so their comments) are never shown, so this just stops the comments from piling up
+// in the database.
+func purgeExpiredComments(comments models.CommentModelInterface, interval time.Duration, logger *slog.Logger, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			n, err := comments.DeleteExpired(context.Background())
+			if err != nil {
+				logger.Error("deleting expired comments", "error", err.Error())
+				continue
+			}
+			if n > 0 {
+				logger.Info("Deleted expired comments", "count", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+func TestCommentsView(t *testing.T) {
+	app := newTestApplication(t)
+
+	t.Run("Anonymous", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		_, _, body := ts.get(t, "/snippet/view/1")
+		assert.StringContains(t, body, `<div class="comment depth-0" id="comment-1">`)
+		assert.StringContains(t, body, "What a <strong>lovely</strong> pond")
+		assert.StringContains(t, body, `<div class="comment depth-1" id="comment-2">`)
+		assert.StringContains(t, body, `<a href="/user/login">Log in</a> to comment.`)
+
+		// The pending comment is only shown to its author and moderators.
+		if strings.Contains(body, "comment-3") {
+			t.Errorf("anonymous users can see a pending comment")
+		}
+
+		if strings.Contains(body, "(edited)") {
+			t.Errorf("comments which haven't been edited are shown as edited")
+		}
+	})
+
+	t.Run("Author", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		ts.login(t)
+		_, _, body := ts.get(t, "/snippet/view/1")
+		assert.StringContains(t, body, `<a href="/comment/edit/1">Edit</a>`)
+		assert.StringContains(t, body, `<form action="/comment/delete/1" method="POST">`)
+		assert.StringContains(t, body, `<form action="/snippet/comment/1" method="POST" class="comment-form" id="comment-form">`)
+		if strings.Contains(body, `/comment/edit/2"`) || strings.Contains(body, "/comment/moderate/") {
+			t.Errorf("users can change other people's comments")
+		}
+	})
+
+	t.Run("Moderator", func(t *testing.T) {
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		ts.loginAs(t, "admin@example.com")
+		_, _, body := ts.get(t, "/snippet/view/1")
+		assert.StringContains(t, body, `id="comment-3"`)
+		assert.StringContains(t, body, "Awaiting moderation")
+		assert.StringContains(t, body, `<button name="action" value="approve">Approve</button>`)
+		assert.StringContains(t, body, `<form action="/comment/delete/1" method="POST">`)
+	})
+}
+
+func TestCommentCreatePost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Posting a comment needs the user to be logged in.
+	_, _, body := ts.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("body", "Hello")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, header, _ := ts.postForm(t, "/snippet/comment/1", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	ts.login(t)
+	_, _, body = ts.get(t, "/snippet/view/1")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		body         string
+		parentID     string
+		csrfToken    string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:         "Valid comment",
+			urlPath:      "/snippet/comment/1",
+			body:         "A *quiet* haiku",
+			csrfToken:    csrfToken,
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1#comment-5",
+		},
+		{
+			name:         "Valid reply",
+			urlPath:      "/snippet/comment/1",
+			body:         "Thanks!",
+			parentID:     "2",
+			csrfToken:    csrfToken,
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/view/1#comment-5",
+		},
+		{
+			name:      "Reply to a pending comment",
+			urlPath:   "/snippet/comment/1",
+			body:      "Is this spam?",
+			parentID:  "3",
+			csrfToken: csrfToken,
+			wantCode:  http.StatusUnprocessableEntity,
+			wantBody:  "The comment you&#39;re replying to has been removed",
+		},
+		{
+			name:      "Empty comment",
+			urlPath:   "/snippet/comment/1",
+			csrfToken: csrfToken,
+			wantCode:  http.StatusUnprocessableEntity,
+			wantBody:  "This field cannot be blank",
+		},
+		{
+			name:      "Too long",
+			urlPath:   "/snippet/comment/1",
+			body:      strings.Repeat("a", 5001),
+			csrfToken: csrfToken,
+			wantCode:  http.StatusUnprocessableEntity,
+			wantBody:  "This field cannot be more than 5000 characters long",
+		},
+		{
+			name:      "Blocklisted",
+			urlPath:   "/snippet/comment/1",
+			body:      "Get CHEAP PONDS here",
+			csrfToken: csrfToken,
+			wantCode:  http.StatusUnprocessableEntity,
+			wantBody:  "This comment contains words which aren&#39;t allowed",
+		},
+		{
+			name:      "Expired snippet",
+			urlPath:   "/snippet/comment/2",
+			body:      "Hello",
+			csrfToken: csrfToken,
+			wantCode:  http.StatusNotFound,
+		},
+		{
+			name:     "Missing CSRF token",
+			urlPath:  "/snippet/comment/1",
+			body:     "Hello",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("body", tt.body)
+			form.Add("parent_id", tt.parentID)
+			form.Add("csrf_token", tt.csrfToken)
+
+			code, header, body := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+
+	// Comments with lots of links are held for moderation.
+	form = url.Values{}
+	form.Add("body", "https://a.example https://b.example https://c.example https://d.example")
+	form.Add("csrf_token", csrfToken)
+	code, _, _ = ts.postForm(t, "/snippet/comment/1", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	_, _, body = ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, "Your comment will be shown once a moderator has approved it")
+}
+
+func TestCommentEditDelete(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+
+	// Alice can edit her own comment, but not the admin's.
+	code, _, body := ts.get(t, "/comment/edit/1")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "What a **lovely** pond</textarea>")
+	csrfToken := extractCSRFToken(t, body)
+
+	code, _, _ = ts.get(t, "/comment/edit/2")
+	assert.Equal(t, code, http.StatusForbidden)
+
+	code, _, _ = ts.get(t, "/comment/edit/99")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	form := url.Values{}
+	form.Add("body", "What a lovely, quiet pond")
+	form.Add("csrf_token", csrfToken)
+	code, header, _ := ts.postForm(t, "/comment/edit/1", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/snippet/view/1#comment-1")
+
+	code, _, _ = ts.postForm(t, "/comment/edit/2", form)
+	assert.Equal(t, code, http.StatusForbidden)
+
+	form = url.Values{}
+	form.Add("csrf_token", csrfToken)
+	code, header, _ = ts.postForm(t, "/comment/delete/1", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/snippet/view/1#comments")
+
+	code, _, _ = ts.postForm(t, "/comment/delete/2", form)
+	assert.Equal(t, code, http.StatusForbidden)
+
+	// Only moderators can moderate comments.
+	form.Add("action", "approve")
+	code, _, _ = ts.postForm(t, "/comment/moderate/3", form)
+	assert.Equal(t, code, http.StatusForbidden)
+}
+
+func TestCommentModeratePost(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.loginAs(t, "admin@example.com")
+	_, _, body := ts.get(t, "/snippet/view/1")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		action   string
+		wantCode int
+	}{
+		{"Approve", "/comment/moderate/3", "approve", http.StatusSeeOther},
+		{"Hide", "/comment/moderate/1", "hide", http.StatusSeeOther},
+		{"Unknown action", "/comment/moderate/1", "delete", http.StatusBadRequest},
+		{"Missing comment", "/comment/moderate/99", "hide", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("action", tt.action)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+
+	// Moderators can delete anyone's comment.
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+	code, _, _ := ts.postForm(t, "/comment/delete/1", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+}
+
+func TestLineComments(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// On the page for the whole snippet the line anchors are prefixed with the filename, and the comment on
+	// the first line of pond.txt is shown after it, rather than with the other comments.
+	_, _, body := ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, `<tr id="pond.txt-L1">`)
+	assert.StringContains(t, body, `<a href="#frog.md-L1">1</a>`)
+	assert.StringContains(t, body, `on <a href="/snippet/view/1/pond.txt#L1">pond.txt L1</a>`)
+	inline := strings.Index(body, `id="comment-4"`)
+	if inline < 0 || inline > strings.Index(body, `<h3 id="comments">`) {
+		t.Errorf("the line comment isn't shown inline")
+	}
+
+	// The page for a single file has its own line anchors.
+	_, _, body = ts.get(t, "/snippet/view/1/pond.txt")
+	assert.StringContains(t, body, `<tr id="L1">`)
+	assert.StringContains(t, body, `<a href="#L1">1</a>`)
+	inline = strings.Index(body, `id="comment-4"`)
+	if inline < 0 || inline > strings.Index(body, `<h3 id="comments">`) {
+		t.Errorf("the line comment isn't shown inline on the file page")
+	}
+
+	// On the page for another file, the comment is shown with the rest.
+	_, _, body = ts.get(t, "/snippet/view/1/frog.md")
+	if strings.Index(body, `id="comment-4"`) < strings.Index(body, `<h3 id="comments">`) {
+		t.Errorf("the line comment is shown inline on the page for another file")
+	}
+
+	ts.login(t)
+	_, _, body = ts.get(t, "/snippet/view/1")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		file     string
+		lines    string
+		parentID string
+		wantCode int
+		wantBody string
+	}{
+		{name: "Line", urlPath: "/snippet/comment/1", file: "pond.txt", lines: "1", wantCode: http.StatusSeeOther},
+		{name: "Range", urlPath: "/snippet/comment/1", file: "frog.md", lines: "L1-L1", wantCode: http.StatusSeeOther},
+		{name: "Only file", urlPath: "/snippet/comment/3", lines: "1", wantCode: http.StatusSeeOther},
+		{name: "Reply", urlPath: "/snippet/comment/1", file: "pond.txt", lines: "99", parentID: "4", wantCode: http.StatusSeeOther},
+		{name: "No file", urlPath: "/snippet/comment/1", lines: "1", wantCode: http.StatusUnprocessableEntity, wantBody: "Choose which file these lines are in"},
+		{name: "Missing file", urlPath: "/snippet/comment/1", file: "toad.txt", lines: "1", wantCode: http.StatusUnprocessableEntity, wantBody: "Choose which file these lines are in"},
+		{name: "Invalid lines", urlPath: "/snippet/comment/1", file: "pond.txt", lines: "2-1", wantCode: http.StatusUnprocessableEntity, wantBody: "Enter a line number, or a range of lines like 10-20"},
+		{name: "Past the end", urlPath: "/snippet/comment/1", file: "pond.txt", lines: "1-2", wantCode: http.StatusUnprocessableEntity, wantBody: "pond.txt only goes up to line 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("body", "Looks good")
+			form.Add("file", tt.file)
+			form.Add("lines", tt.lines)
+			form.Add("parent_id", tt.parentID)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressMinSize is the smallest response body worth compressing. Below this, the compression headers and
+// overhead make the response bigger, or at best save a negligible number of bytes.
+const compressMinSize = 1024
+
+// negotiateEncoding picks the best content coding that we support from an Accept-Encoding header, preferring
+// brotli over gzip when the client likes them equally. It returns "" if the response shouldn't be compressed.
+func negotiateEncoding(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		switch coding {
+		case "br", "*":
+			if q > 0 && (q > bestQ || (q == bestQ && best != "br")) {
+				best, bestQ = "br", q
+			}
+		case "gzip":
+			if q > 0 && q > bestQ {
+				best, bestQ = "gzip", q
+			}
+		}
+	}
+
+	return best
+}
+
+// compressibleType reports whether responses with the given Content-Type are worth compressing. Images,
+// archives and so on are already compressed, so compressing them again would just waste CPU.
+func compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml", "image/svg+xml", "image/x-icon":
+		return true
+	}
+
+	return false
+}
+
+// compressResponse is a middleware which compresses response bodies with brotli or gzip, depending on what
+// the client accepts. Handlers don't need to do anything differently: the first compressMinSize bytes of the
+// body are held back, and once we know the response is big enough we decide whether to compress it based on
+// its status and headers. Responses which already have a Content-Encoding (like precompressed static files)
+// are left alone.
+func compressResponse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		next.ServeHTTP(cw, r)
+
+		// This isn't deferred, as if the handler panics we mustn't finish the response. Sending the buffered
+		// body or the compressor's trailer would make a truncated response look complete, so it's dropped and
+		// the panic carries on up to recoverPanic, which sends an error page if nothing has been sent yet.
+		cw.Close()
+	})
+}
+
+// compressWriter wraps a http.ResponseWriter, compressing the body if it turns out to be worth it.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	enc      io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	// Informational responses (like 103 Early Hints) are sent straight away, and are followed by the real
+	// response.
+	if code >= 100 && code < 200 {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	cw.status = code
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < compressMinSize {
+			return len(b), nil
+		}
+
+		err := cw.decide(true)
+		return len(b), err
+	}
+
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// decide chooses whether to compress the response, sends the headers and writes out any buffered body. If
+// bigEnough is false, the response is only compressed if it has at least compressMinSize bytes buffered.
+func (cw *compressWriter) decide(bigEnough bool) error {
+	cw.decided = true
+	h := cw.Header()
+
+	// The http.ResponseWriter would normally sniff the content type from the first bytes written, but it
+	// would see the compressed bytes, so we need to do it ourselves.
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	compress := (bigEnough || len(cw.buf) >= compressMinSize) &&
+		cw.status == http.StatusOK &&
+		h.Get("Content-Encoding") == "" &&
+		h.Get("Content-Range") == "" &&
+		compressibleType(h.Get("Content-Type"))
+
+	if compress {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+
+		// A strong ETag identifies the exact bytes of a response, so the compressed response can only have a
+		// weak one.
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			h.Set("ETag", "W/"+etag)
+		}
+
+		if cw.encoding == "br" {
+			cw.enc = brotli.NewWriterLevel(cw.ResponseWriter, 5)
+		} else {
+			cw.enc = gzip.NewWriter(cw.ResponseWriter)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+// Flush sends any buffered data to the client. Streaming responses are compressed as soon as they are
+// flushed, as they are usually big and we can't wait to find out.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.decide(true)
+	}
+
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close finishes the response, writing out the rest of the body.
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		// If nothing at all was written, leave it to the server to send an empty response.
+		if cw.status == 0 {
+			return nil
+		}
+		err := cw.decide(false)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cw.enc != nil {
+		return cw.enc.Close()
+	}
+	return nil
+}
+
+// Unwrap returns the underlying http.ResponseWriter, so that http.ResponseController can reach it.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
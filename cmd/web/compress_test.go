@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"br;q=0, gzip;q=0", ""},
+		{"GZIP;q=0.8", "gzip"},
+		{"*", "br"},
+		{"gzip;q=bad", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			assert.Equal(t, negotiateEncoding(tt.acceptEncoding), tt.want)
+		})
+	}
+}
+
+func TestCompressResponse(t *testing.T) {
+	large := strings.Repeat("An old silent pond... ", 200)
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           string
+		wantEncoding   string
+	}{
+		{
+			name:           "Brotli",
+			acceptEncoding: "gzip, br",
+			body:           large,
+			wantEncoding:   "br",
+		},
+		{
+			name:           "Gzip",
+			acceptEncoding: "gzip",
+			body:           large,
+			wantEncoding:   "gzip",
+		},
+		{
+			name:           "Not accepted",
+			acceptEncoding: "identity",
+			body:           large,
+		},
+		{
+			name:           "Too small",
+			acceptEncoding: "gzip, br",
+			body:           "OK",
+		},
+		{
+			name:           "Already compressed type",
+			acceptEncoding: "gzip, br",
+			contentType:    "image/png",
+			body:           large,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.Header().Set("ETag", `"abc"`)
+				// Write the body in small pieces, like the template engine does.
+				for _, line := range strings.SplitAfter(tt.body, "...") {
+					io.WriteString(w, line)
+				}
+			})
+
+			rr := httptest.NewRecorder()
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+
+			compressResponse(next).ServeHTTP(rr, r)
+
+			rs := rr.Result()
+			assert.Equal(t, rs.StatusCode, http.StatusOK)
+			assert.Equal(t, rs.Header.Get("Vary"), "Accept-Encoding")
+			assert.Equal(t, rs.Header.Get("Content-Encoding"), tt.wantEncoding)
+
+			var body io.Reader = rs.Body
+			switch tt.wantEncoding {
+			case "br":
+				body = brotli.NewReader(rs.Body)
+			case "gzip":
+				body, err = gzip.NewReader(rs.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			b, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, string(b), tt.body)
+
+			if tt.wantEncoding != "" {
+				assert.Equal(t, rs.Header.Get("ETag"), `W/"abc"`)
+			} else {
+				assert.Equal(t, rs.Header.Get("ETag"), `"abc"`)
+			}
+		})
+	}
+}
+
+func TestCompressResponsePanic(t *testing.T) {
+	large := strings.Repeat("An old silent pond... ", 200)
+
+	tests := []struct {
+		name         string
+		body         string
+		wantEncoding string
+	}{
+		{
+			name: "Nothing sent yet",
+			body: "OK",
+		},
+		{
+			name:         "Partly sent",
+			body:         large,
+			wantEncoding: "gzip",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, tt.body)
+				panic("oops")
+			})
+
+			rr := httptest.NewRecorder()
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header.Set("Accept-Encoding", "gzip")
+
+			func() {
+				defer func() {
+					assert.Equal(t, recover(), any("oops"))
+				}()
+				compressResponse(next).ServeHTTP(rr, r)
+			}()
+
+			rs := rr.Result()
+			assert.Equal(t, rs.Header.Get("Content-Encoding"), tt.wantEncoding)
+
+			if tt.wantEncoding == "" {
+				// The buffered body is dropped, so that an error page can be sent instead.
+				assert.Equal(t, rr.Body.Len(), 0)
+				return
+			}
+
+			// The compressed body is cut off without its trailer, so the client can tell it's incomplete.
+			gr, err := gzip.NewReader(rs.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = io.ReadAll(gr)
+			assert.Equal(t, err, io.ErrUnexpectedEOF)
+		})
+	}
+}
+
+func TestServeStaticPrecompressed(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	asset := app.staticAssets["static/css/main.css"]
+	if asset.brotli == nil {
+		t.Skip("main.css is too small to be precompressed")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/static/css/main.css", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "br")
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+
+	assert.Equal(t, rs.Header.Get("Content-Encoding"), "br")
+	assert.Equal(t, rs.Header.Get("Content-Type"), "text/css; charset=utf-8")
+
+	b, err := io.ReadAll(brotli.NewReader(rs.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, bytes.Equal(b, asset.content), true)
+}
@@ -3,3 +3,8 @@ package main
 type contextKey string
 
 const isAuthenticatedContextKey = contextKey("isAuthenticated")
+const authenticatedUserContextKey = contextKey("authenticatedUser")
+const clientCertLoginContextKey = contextKey("clientCertLogin")
+const requestIDContextKey = contextKey("requestID")
+const requestLogContextKey = contextKey("requestLog")
+const cspNonceContextKey = contextKey("cspNonce")
@@ -3,8 +3,11 @@ package main
 import (
 	"errors"
 	"fmt"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-playground/form/v4"
 	"github.com/vishal-rfx/snippetbox/internal/models"
@@ -12,7 +15,7 @@ import (
 )
 
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
-	snippets, err := app.snippets.Latest()
+	snippets, err := app.snippets.Latest(r.Context(), "")
 	if err != nil {
 		app.serverError(w, r, err)
 		return
@@ -24,6 +27,46 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	app.render(w, r, http.StatusOK, "home.tmpl.html", data)
 }
 
+// tags shows the tags in use, with the number of snippets which have each of them.
+func (app *application) tags(w http.ResponseWriter, r *http.Request) {
+	tags, err := app.snippets.Tags(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.TagCounts = tags
+
+	app.render(w, r, http.StatusOK, "tags.tmpl.html", data)
+}
+
+// tagView lists the latest snippets with a tag. Tags which aren't in their normalized form are redirected,
+// so that each tag has a single URL.
+func (app *application) tagView(w http.ResponseWriter, r *http.Request) {
+	tag := models.NormalizeTag(r.PathValue("tag"))
+	if !validator.MaxChars(tag, models.MaxTagLength) || !validator.Matches(tag, models.TagRX) {
+		http.NotFound(w, r)
+		return
+	}
+	if tag != r.PathValue("tag") {
+		http.Redirect(w, r, app.url("/tags/"+tag), http.StatusMovedPermanently)
+		return
+	}
+
+	snippets, err := app.snippets.Latest(r.Context(), tag)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Tag = tag
+	data.Snippets = snippets
+
+	app.render(w, r, http.StatusOK, "tag.tmpl.html", data)
+}
+
 func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 	// Extract the value of the id wildcard from the request using r.PathValue()
 	// and try to convert it to an integer using the strconv.Atoi() function
@@ -35,7 +78,7 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	snippet, err := app.snippets.Get(id)
+	snippet, err := app.snippets.Get(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			http.NotFound(w, r)
@@ -45,11 +88,106 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data := app.newTemplateData(r)
-	data.Snippet = snippet
+	// Snippets can't be edited, so the page only changes when the snippet is forked or commented on (or if
+	// the site is updated, which the ETag takes care of).
+	data, lastModified, err := app.snippetPageData(r, snippet)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.renderCacheable(w, r, "view.tmpl.html", data, lastModified, snippet.Expires)
+
+}
+
+// snippetFileView shows a single file from a snippet.
+func (app *application) snippetFileView(w http.ResponseWriter, r *http.Request) {
+	snippet, file, ok := app.snippetFile(w, r)
+	if !ok {
+		return
+	}
+
+	// The comments are shown on the file's page too, so that the comments about its lines can be read
+	// alongside them.
+	snippet.Files = []models.SnippetFile{file}
+	data, lastModified, err := app.snippetPageData(r, snippet)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data.File = file.Name
+
+	app.renderCacheable(w, r, "view.tmpl.html", data, lastModified, snippet.Expires)
+}
+
+// snippetFileRaw sends the content of a single file from a snippet as plain text, or as a download if the
+// download query string parameter is set. It's always sent as plain text (whatever the language of the
+// file) so that browsers never run a snippet as HTML or JavaScript.
+func (app *application) snippetFileRaw(w http.ResponseWriter, r *http.Request) {
+	snippet, file, ok := app.snippetFile(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.URL.Query().Has("download") {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+	}
+
+	// ServeContent takes care of conditional and range requests for us.
+	http.ServeContent(w, r, "", snippet.Created, strings.NewReader(file.Content))
+}
+
+// snippetFile looks up the snippet and file named in the request path. If there's no such snippet or file
+// it sends an error response and returns false.
+func (app *application) snippetFile(w http.ResponseWriter, r *http.Request) (models.Snippet, models.SnippetFile, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return models.Snippet{}, models.SnippetFile{}, false
+	}
+
+	snippet, err := app.snippets.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return models.Snippet{}, models.SnippetFile{}, false
+	}
+
+	for _, file := range snippet.Files {
+		if file.Name == r.PathValue("file") {
+			return snippet, file, true
+		}
+	}
+
+	http.NotFound(w, r)
+	return models.Snippet{}, models.SnippetFile{}, false
+}
+
+// snippetDeletePost lets moderators and admins delete any snippet.
+func (app *application) snippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	err = app.snippets.Delete(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
 
-	app.render(w, r, http.StatusOK, "view.tmpl.html", data)
+	app.logger.InfoContext(r.Context(), "Snippet deleted", "id", id, "by", app.authenticatedUser(r).ID)
 
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Snippet #%d has been deleted", id))
+	http.Redirect(w, r, app.url("/"), http.StatusSeeOther)
 }
 
 // Define a snippetCreateForm struct to represent the form data and validation errors
@@ -58,17 +196,63 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 // the template.
 type snippetCreateForm struct {
 	Title       string	`form:"title"`
-	Content     string	`form:"content"`
+	Files       []snippetFileForm	`form:"files"`
 	Expires     int	`form:"expires"`
+	Tags        string	`form:"tags"`
+	// ForkOf is the ID of the snippet which this one is being forked from, or 0.
+	ForkOf      int	`form:"fork_of"`
+	// AddFile and RemoveFile are set by the buttons for adding and removing files, which submit the form
+	// without publishing the snippet.
+	AddFile     bool	`form:"add_file"`
+	RemoveFile  *int	`form:"remove_file"`
 	validator.Validator	`form:"-"`
 }
 
+// snippetFileForm holds the fields for one of the files in the snippet create form. An empty language means
+// that it's detected from the filename.
+type snippetFileForm struct {
+	Name     string	`form:"name"`
+	Language string	`form:"language"`
+	Content  string	`form:"content"`
+}
+
 func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
-	data := app.newTemplateData(r)
-	data.Form = snippetCreateForm{
+	form := snippetCreateForm{
+		Files: []snippetFileForm{{}},
 		Expires: 365,
 	}
 
+	// Forking a snippet opens the create form filled in with a copy of it, for the user to change before
+	// they publish their own version.
+	if r.URL.Query().Has("fork") {
+		id, err := strconv.Atoi(r.URL.Query().Get("fork"))
+		if err != nil || id < 1 {
+			http.NotFound(w, r)
+			return
+		}
+
+		parent, err := app.snippets.Get(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				http.NotFound(w, r)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+
+		form.Title = parent.Title
+		form.Tags = strings.Join(parent.Tags, ", ")
+		form.ForkOf = parent.ID
+		form.Files = make([]snippetFileForm, len(parent.Files))
+		for i, f := range parent.Files {
+			form.Files[i] = snippetFileForm{Name: f.Name, Language: f.Language, Content: f.Content}
+		}
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+
 	app.render(w, r, http.StatusOK, "create.tmpl.html", data)
 }
 
@@ -80,13 +264,70 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Adding or removing a file just shows the form again with the change made. This works without any
+	// JavaScript.
+	if form.AddFile || form.RemoveFile != nil {
+		if form.AddFile && len(form.Files) < models.MaxFiles {
+			form.Files = append(form.Files, snippetFileForm{})
+		}
+		if i := form.RemoveFile; i != nil && *i >= 0 && *i < len(form.Files) && len(form.Files) > 1 {
+			form.Files = append(form.Files[:*i], form.Files[*i+1:]...)
+		}
+		form.AddFile, form.RemoveFile = false, nil
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusOK, "create.tmpl.html", data)
+		return
+	}
+
 	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
 	form.CheckField(validator.MaxChars(form.Title, 100), "title","This field cannot be more than 100 characters long")
-	form.CheckField(validator.NotBlank(form.Content), "content", "This field cannot be blank")
 	form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365")
 
+	// Check each of the files, and then the files as a whole. The errors for each file are keyed by its
+	// position in the form, like "files.0.name".
+	form.CheckField(len(form.Files) > 0, "files", "A snippet must have at least one file")
+	form.CheckField(len(form.Files) <= models.MaxFiles, "files", fmt.Sprintf("A snippet can have at most %d files", models.MaxFiles))
+
+	names := make([]string, len(form.Files))
+	size := 0
+	for i, f := range form.Files {
+		key := fmt.Sprintf("files.%d.", i)
+		form.CheckField(validator.NotBlank(f.Name), key+"name", "This field cannot be blank")
+		form.CheckField(validator.MaxChars(f.Name, models.MaxFilenameLength), key+"name", fmt.Sprintf("This field cannot be more than %d characters long", models.MaxFilenameLength))
+		form.CheckField(f.Name == "" || validator.Matches(f.Name, models.FilenameRX), key+"name", "Filenames can only contain letters, digits, dots, underscores and hyphens, and can't start with a dot")
+		form.CheckField(f.Language == "" || validator.PermittedValue(f.Language, models.LanguageIDs()...), key+"language", "This language isn't supported")
+		form.CheckField(validator.NotBlank(f.Content), key+"content", "This field cannot be blank")
+
+		names[i] = strings.ToLower(f.Name)
+		size += len(f.Content)
+	}
+	form.CheckField(validator.Unique(names), "files", "Each file must have a different name")
+	form.CheckField(size <= models.MaxSnippetSize, "files", fmt.Sprintf("The files cannot be more than %d KB in total", models.MaxSnippetSize>>10))
+
+	// The tags are normalized before they're checked, so "Svc-Foo, #svc-foo" is a single valid tag.
+	tags := models.ParseTags(form.Tags)
+	form.CheckField(len(tags) <= models.MaxTags, "tags", fmt.Sprintf("A snippet can have at most %d tags", models.MaxTags))
+	for _, tag := range tags {
+		form.CheckField(validator.MaxChars(tag, models.MaxTagLength), "tags", fmt.Sprintf("Tags cannot be more than %d characters long", models.MaxTagLength))
+		form.CheckField(validator.Matches(tag, models.TagRX), "tags", "Tags can only contain letters, digits, dots, underscores and hyphens")
+	}
+
+	// If the snippet being forked has expired (or been deleted) since the form was opened, let the user know
+	// and publish their copy as a new snippet if they submit the form again.
+	if form.ForkOf != 0 {
+		_, err := app.snippets.Get(r.Context(), form.ForkOf)
+		if err != nil {
+			if !errors.Is(err, models.ErrNoRecord) {
+				app.serverError(w, r, err)
+				return
+			}
+			form.AddNonFieldError(fmt.Sprintf("Snippet #%d has expired or been deleted, so this will be published as a new snippet", form.ForkOf))
+			form.ForkOf = 0
+		}
+	}
 
-	
 	// If there are any errors, dump them in a plain text HTTP response and return for the handler.
 	if !form.Valid() {
 		data := app.newTemplateData(r)
@@ -95,20 +336,30 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	files := make([]models.SnippetFile, len(form.Files))
+	for i, f := range form.Files {
+		language := f.Language
+		if language == "" {
+			language = models.DetectLanguage(f.Name)
+		}
+		files[i] = models.SnippetFile{Name: f.Name, Language: language, Content: f.Content, Position: i}
+	}
+
 	// Pass the data to the SnippetModel.Insert() method, receiving the ID of the new record back.
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
-	app.logger.Debug("Inserted", "id", id)
+	id, err := app.snippets.Insert(r.Context(), app.authenticatedUser(r).ID, form.ForkOf, form.Title, files, form.Expires, tags)
+	app.logger.DebugContext(r.Context(), "Inserted", "id", id)
 
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
+	app.metrics.snippetsCreated.Inc()
 
 	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully created!")
 
 	// Redirect the user to the relevant page for the snippet
-	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+	http.Redirect(w, r, app.url(fmt.Sprintf("/snippet/view/%d", id)), http.StatusSeeOther)
 }
 
 
@@ -171,7 +422,7 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request){
 
 	// Try to create a new user record in the database. If the email already exists then add an error message 
 	// to the form and re-display it.
-	err = app.users.Insert(form.Name, form.Email, form.Password)
+	err = app.users.Insert(r.Context(), form.Name, form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrDuplicateEmail) {
 			form.AddFieldError("email", "Email address is already in use")
@@ -187,7 +438,7 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request){
 
 	// Otherwise add a confirmation flash message to the session confirming that their signup worked
 	app.sessionManager.Put(r.Context(), "flash", "Your signup was successfull. Please log in")
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	http.Redirect(w, r, app.url("/user/login"), http.StatusSeeOther)
 
 }
 
@@ -195,6 +446,7 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request){
 type userLoginForm struct {
 	Email string `form:"email"`
 	Password string `form:"password"`
+	Remember bool `form:"remember"`
 	validator.Validator `form:"-"`
 }
 
@@ -224,36 +476,85 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request){
 		return
 	}
 
-	id, err := app.users.Authenticate(form.Email, form.Password)
+	id, err := app.users.Authenticate(r.Context(), form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrInvalidCredentials) {
+			app.metrics.loginsFailed.WithLabelValues("password", "invalid_credentials").Inc()
 			form.AddNonFieldError("Email or Password is incorrect")
 			data := app.newTemplateData(r)
 			data.Form = form
 			app.render(w, r, http.StatusUnprocessableEntity, "login.tmpl.html", data)
+		} else if errors.Is(err, models.ErrLocalAccount) {
+			app.metrics.loginsFailed.WithLabelValues("password", "local_account").Inc()
+			form.AddNonFieldError("An account with this email address already exists. Log in with that account's password to link it to your directory account")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "login.tmpl.html", data)
+		} else if errors.Is(err, models.ErrAccountDisabled) {
+			app.metrics.loginsFailed.WithLabelValues("password", "account_disabled").Inc()
+			form.AddNonFieldError("Your account has been disabled")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusForbidden, "login.tmpl.html", data)
 		} else {
 			app.serverError(w, r, err)
 		}
 		return
 	}
 
-	// Use the RenewToken method on the current session to change the session ID. It's good practice to 
-	// generate a new seesion ID when the authentication state or privilege levels changes for the user
-	// (e.g. login and logout operation)	
-	err = app.sessionManager.RenewToken(r.Context())
+	err = app.startAuthenticatedSession(r, id)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
-	// Add the ID of the current user to the session, so that they are now logged in
-	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
+	app.metrics.loginsSucceeded.WithLabelValues("password").Inc()
+
+	// Forget any remember token this device already holds, so that old tokens don't pile up (and stay valid)
+	// every time someone logs in again.
+	if series, _, ok := readRememberCookie(r); ok {
+		err = app.rememberTokens.Delete(r.Context(), series)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.clearRememberCookie(w, r)
+	}
+
+	// If the user ticked "remember me", issue a long-lived remember token linked to the new session, so that
+	// they are logged back in transparently once the session itself expires.
+	if form.Remember {
+		token, err := app.rememberTokens.Insert(r.Context(), id, app.sessionManager.Token(r.Context()), time.Now().Add(app.rememberLifetime))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		app.setRememberCookie(w, r, token)
+	}
 
-	http.Redirect(w, r, "/snippet/create/", http.StatusSeeOther)
+	http.Redirect(w, r, app.url("/snippet/create/"), http.StatusSeeOther)
 
 }
 
 func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request){
-	err := app.sessionManager.RenewToken(r.Context())
+	// Remove the metadata for the current session before its token is renewed.
+	err := app.userSessions.Delete(r.Context(), app.sessionManager.Token(r.Context()))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Forget the remember token for this device, if there is one.
+	if series, _, ok := readRememberCookie(r); ok {
+		err = app.rememberTokens.Delete(r.Context(), series)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+	app.clearRememberCookie(w, r)
+
+	err = app.sessionManager.RenewToken(r.Context())
 	if err != nil {
 		app.serverError(w, r, err)
 		return
@@ -263,7 +564,142 @@ func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request){
 
 	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully")
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	http.Redirect(w, r, app.url("/"), http.StatusSeeOther)
+}
+
+// account shows the user's details, with links to manage their password, sessions and single sign-on.
+func (app *application) account(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.User = app.authenticatedUser(r)
+	app.render(w, r, http.StatusOK, "account.tmpl.html", data)
+}
+
+type accountPasswordUpdateForm struct {
+	CurrentPassword string `form:"currentPassword"`
+	NewPassword string `form:"newPassword"`
+	NewPasswordConfirmation string `form:"newPasswordConfirmation"`
+	validator.Validator `form:"-"`
+}
+
+func (app *application) accountPasswordUpdate(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = accountPasswordUpdateForm{}
+	app.render(w, r, http.StatusOK, "password.tmpl.html", data)
+}
+
+func (app *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http.Request) {
+	var form accountPasswordUpdateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.CurrentPassword), "currentPassword", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword", "This field cannot be blank")
+	form.CheckField(validator.MinChars(form.NewPassword, 8), "newPassword", "This field must be atleast 8 characters long")
+	form.CheckField(form.NewPassword == form.NewPasswordConfirmation, "newPasswordConfirmation", "Passwords do not match")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "password.tmpl.html", data)
+		return
+	}
+
+	userID := app.authenticatedUser(r).ID
+
+	err = app.users.PasswordUpdate(r.Context(), userID, form.CurrentPassword, form.NewPassword)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddFieldError("currentPassword", "Current password is incorrect")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "password.tmpl.html", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been updated!")
+	http.Redirect(w, r, app.url("/"), http.StatusSeeOther)
+}
+
+func (app *application) accountSessions(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUser(r).ID
+
+	sessions, err := app.userSessions.GetAll(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.UserSessions = sessions
+	data.CurrentSessionToken = app.sessionManager.Token(r.Context())
+
+	app.render(w, r, http.StatusOK, "sessions.tmpl.html", data)
+}
+
+// accountSessionRevokePost signs out a single other device by deleting its session from the session store.
+// The current session can't be revoked this way, the user should log out instead.
+func (app *application) accountSessionRevokePost(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	userID := app.authenticatedUser(r).ID
+
+	session, err := app.userSessions.Get(r.Context(), id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.NotFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if session.Token == app.sessionManager.Token(r.Context()) {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err = app.revokeSession(r.Context(), session.Token)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "The device has been signed out")
+	http.Redirect(w, r, app.url("/account/sessions"), http.StatusSeeOther)
+}
+
+// accountSessionsRevokeOthersPost signs out every session belonging to the user except the current one.
+func (app *application) accountSessionsRevokeOthersPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUser(r).ID
+
+	err := app.revokeAllSessions(r.Context(), userID, app.sessionManager.Token(r.Context()))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Devices whose session has already expired may still hold a remember token, so delete every one of those
+	// apart from this device's too.
+	series, _, _ := readRememberCookie(r)
+	err = app.rememberTokens.DeleteAllForUser(r.Context(), userID, series)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "All other devices have been signed out")
+	http.Redirect(w, r, app.url("/account/sessions"), http.StatusSeeOther)
 }
 
 func ping(w http.ResponseWriter, _ *http.Request) {
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/vishal-rfx/snippetbox/internal/assert"
+	"github.com/vishal-rfx/snippetbox/internal/models/mocks"
 )
 
 func TestPing(t *testing.T) {
@@ -78,6 +81,385 @@ func TestSnippetView(t *testing.T){
 
 }
 
+func TestTags(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:     "All tags",
+			urlPath:  "/tags",
+			wantCode: http.StatusOK,
+			wantBody: `<a href="/tags/haiku">haiku</a>`,
+		},
+		{
+			name:     "Tag",
+			urlPath:  "/tags/haiku",
+			wantCode: http.StatusOK,
+			wantBody: "An old silent pond",
+		},
+		{
+			name:     "Unused tag",
+			urlPath:  "/tags/limerick",
+			wantCode: http.StatusOK,
+			wantBody: "There are no snippets with this tag.",
+		},
+		{
+			name:         "Tag which isn't normalized",
+			urlPath:      "/tags/Haiku",
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "/tags/haiku",
+		},
+		{
+			name:     "Invalid tag",
+			urlPath:  "/tags/-haiku",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantLocation != "" {
+				assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			}
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+
+	// The tags are shown as links on the home page and the snippet page.
+	for _, urlPath := range []string{"/", "/snippet/view/1"} {
+		_, _, body := ts.get(t, urlPath)
+		assert.StringContains(t, body, `<li><a href="/tags/haiku">haiku</a></li>`)
+	}
+}
+
+func TestSnippetCreateTags(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+	_, _, body := ts.get(t, "/snippet/create/")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		tags     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "No tags",
+			tags:     "",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Tags which need normalizing",
+			tags:     "Svc-Foo, #svc-foo go",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Too many tags",
+			tags:     "a b c d e f",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "A snippet can have at most 5 tags",
+		},
+		{
+			name:     "Tag too long",
+			tags:     strings.Repeat("a", 33),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Tags cannot be more than 32 characters long",
+		},
+		{
+			name:     "Invalid characters",
+			tags:     "svc/foo",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Tags can only contain letters, digits, dots, underscores and hyphens",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "An old silent pond")
+			form.Add("files[0].name", "pond.txt")
+			form.Add("files[0].content", "An old silent pond...")
+			form.Add("expires", "7")
+			form.Add("tags", tt.tags)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/snippet/create/", form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSnippetFile(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The snippet page shows all the files, in order.
+	code, _, body := ts.get(t, "/snippet/view/1")
+	assert.Equal(t, code, http.StatusOK)
+	pond, frog := strings.Index(body, "An old silent pond..."), strings.Index(body, "A frog jumps into the pond,")
+	if pond < 0 || frog < pond {
+		t.Errorf("files missing or out of order: %q", body)
+	}
+	assert.StringContains(t, body, `<code class="language-markdown">`)
+
+	tests := []struct {
+		name                   string
+		urlPath                string
+		wantCode               int
+		wantContentType        string
+		wantContentDisposition string
+		wantBody               string
+	}{
+		{
+			name:            "View file",
+			urlPath:         "/snippet/view/1/frog.md",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        "A frog jumps into the pond,",
+		},
+		{
+			name:            "Raw file",
+			urlPath:         "/snippet/raw/1/frog.md",
+			wantCode:        http.StatusOK,
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "A frog jumps into the pond,",
+		},
+		{
+			name:                   "Download file",
+			urlPath:                "/snippet/raw/1/frog.md?download",
+			wantCode:               http.StatusOK,
+			wantContentType:        "text/plain; charset=utf-8",
+			wantContentDisposition: `attachment; filename=frog.md`,
+			wantBody:               "A frog jumps into the pond,",
+		},
+		{
+			name:     "Non-existent file",
+			urlPath:  "/snippet/raw/1/toad.md",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Non-existent snippet",
+			urlPath:  "/snippet/raw/2/frog.md",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantContentType != "" {
+				assert.Equal(t, header.Get("Content-Type"), tt.wantContentType)
+			}
+			assert.Equal(t, header.Get("Content-Disposition"), tt.wantContentDisposition)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+
+	// The page for a single file doesn't show the others.
+	_, _, body = ts.get(t, "/snippet/view/1/frog.md")
+	if strings.Contains(body, "An old silent pond...") {
+		t.Error("file page shows the other files")
+	}
+}
+
+func TestSnippetCreateFiles(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+	_, _, body := ts.get(t, "/snippet/create/")
+	csrfToken := extractCSRFToken(t, body)
+
+	// newForm returns a valid form with the given files, as pairs of names and contents.
+	newForm := func(files ...string) url.Values {
+		form := url.Values{}
+		form.Add("title", "Compose")
+		form.Add("expires", "7")
+		form.Add("csrf_token", csrfToken)
+		for i := 0; i+1 < len(files); i += 2 {
+			form.Add(fmt.Sprintf("files[%d].name", i/2), files[i])
+			form.Add(fmt.Sprintf("files[%d].content", i/2), files[i+1])
+		}
+		return form
+	}
+
+	tests := []struct {
+		name     string
+		form     url.Values
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Several files",
+			form:     newForm("Dockerfile", "FROM golang", "compose.yml", "services:", "run.sh", "docker compose up"),
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "No files",
+			form:     newForm(),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "A snippet must have at least one file",
+		},
+		{
+			name:     "Too many files",
+			form:     newForm(strings.Split(strings.Repeat("f.txt,x,", 11), ",")...),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "A snippet can have at most 10 files",
+		},
+		{
+			name:     "Duplicate names",
+			form:     newForm("run.sh", "a", "RUN.sh", "b"),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Each file must have a different name",
+		},
+		{
+			name:     "Invalid name",
+			form:     newForm("../run.sh", "a"),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Filenames can only contain letters, digits, dots, underscores and hyphens",
+		},
+		{
+			name:     "Blank content",
+			form:     newForm("run.sh", " "),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "This field cannot be blank",
+		},
+		{
+			name:     "Too large",
+			form:     newForm("a.txt", strings.Repeat("a", 300<<10), "b.txt", strings.Repeat("b", 300<<10)),
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "The files cannot be more than 512 KB in total",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.postForm(t, "/snippet/create/", tt.form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+			}
+		})
+	}
+
+	// Adding and removing files shows the form again, keeping what's been typed in.
+	form := newForm("Dockerfile", "FROM golang")
+	form.Add("add_file", "true")
+	code, _, body := ts.postForm(t, "/snippet/create/", form)
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `name="files[1].name"`)
+	assert.StringContains(t, body, "FROM golang")
+
+	form = newForm("Dockerfile", "FROM golang", "compose.yml", "services:")
+	form.Add("remove_file", "0")
+	code, _, body = ts.postForm(t, "/snippet/create/", form)
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `value="compose.yml"`)
+	if strings.Contains(body, `name="files[1].name"`) {
+		t.Error("file wasn't removed")
+	}
+}
+
+func TestSnippetFork(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// The original lists its forks, and the fork links back to the original.
+	_, _, body := ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, `<a href="/snippet/view/3">#3 An old noisy pond</a>`)
+
+	_, _, body = ts.get(t, "/snippet/view/3")
+	assert.StringContains(t, body, `forked from <a href="/snippet/view/1">#1</a>`)
+
+	// Forking needs the user to be logged in.
+	code, _, _ := ts.get(t, "/snippet/create/?fork=1")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	ts.login(t)
+	_, _, body = ts.get(t, "/snippet/view/1")
+	assert.StringContains(t, body, `<a href="/snippet/create/?fork=1">Fork</a>`)
+
+	// The create form is filled in with a copy of the original.
+	code, _, body = ts.get(t, "/snippet/create/?fork=1")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `<input type="hidden" name="fork_of" value="1">`)
+	assert.StringContains(t, body, `value="An old silent pond"`)
+	assert.StringContains(t, body, `value="frog.md"`)
+	assert.StringContains(t, body, "A frog jumps into the pond,")
+	assert.StringContains(t, body, `value="haiku"`)
+	csrfToken := extractCSRFToken(t, body)
+
+	for _, urlPath := range []string{"/snippet/create/?fork=2", "/snippet/create/?fork=foo"} {
+		code, _, _ = ts.get(t, urlPath)
+		assert.Equal(t, code, http.StatusNotFound)
+	}
+
+	tests := []struct {
+		name     string
+		forkOf   string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Fork",
+			forkOf:   "1",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Original has expired",
+			forkOf:   "2",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: "Snippet #2 has expired or been deleted, so this will be published as a new snippet",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", "An old noisy pond")
+			form.Add("files[0].name", "pond.txt")
+			form.Add("files[0].content", "An old noisy pond...")
+			form.Add("expires", "7")
+			form.Add("fork_of", tt.forkOf)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/snippet/create/", form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.StringContains(t, body, tt.wantBody)
+				if strings.Contains(body, `name="fork_of"`) {
+					t.Error("form still refers to the expired snippet")
+				}
+			}
+		})
+	}
+}
+
 func TestUserSignup(t *testing.T) {
 	// Create the application struct containing our mocked dependencies and set up the test
 	// server for running an end-to-end test.
@@ -193,4 +575,180 @@ func TestUserSignup(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestAccountSessions(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Unauthenticated users should be redirected to the login page.
+	code, header, _ := ts.get(t, "/account/sessions")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	ts.login(t)
+
+	code, _, body := ts.get(t, "/account/sessions")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "Conference Room Browser")
+	assert.StringContains(t, body, "/account/sessions/revoke/1")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+	}{
+		{
+			name:     "Revoke other device",
+			urlPath:  "/account/sessions/revoke/1",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Revoke non-existent session",
+			urlPath:  "/account/sessions/revoke/2",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Revoke all other devices",
+			urlPath:  "/account/sessions/revoke-others",
+			wantCode: http.StatusSeeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+}
+
+func TestUserLoginRemember(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("email", "alice@example.com")
+	form.Add("password", "password")
+	form.Add("remember", "true")
+	form.Add("csrf_token", csrfToken)
+
+	rs, err := ts.Client().PostForm(ts.URL+"/user/login", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Body.Close()
+
+	assert.Equal(t, rs.StatusCode, http.StatusSeeOther)
+
+	var remember *http.Cookie
+	for _, cookie := range rs.Cookies() {
+		if cookie.Name == rememberCookieName {
+			remember = cookie
+		}
+	}
+	if remember == nil {
+		t.Fatal("no remember me cookie set")
+	}
+	assert.Equal(t, remember.Value, "series:validator")
+	assert.Equal(t, remember.HttpOnly, true)
+	assert.Equal(t, remember.Secure, true)
+}
+
+func TestUserLoginReplacesRememberToken(t *testing.T) {
+	app := newTestApplication(t)
+	rememberTokens := &mocks.RememberTokenModel{}
+	app.rememberTokens = rememberTokens
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+
+	// The device still holds a remember token from an earlier login.
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Client().Jar.SetCookies(u, []*http.Cookie{{Name: rememberCookieName, Value: "old-series:validator"}})
+
+	form := url.Values{}
+	form.Add("email", "alice@example.com")
+	form.Add("password", "password")
+	form.Add("remember", "true")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, header, _ := ts.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, strings.Join(rememberTokens.Deleted, ","), "old-series")
+
+	// The cookie for the new token comes after the old one is cleared, so it's the one the browser keeps.
+	rs := http.Response{Header: header}
+	var remember []string
+	for _, cookie := range rs.Cookies() {
+		if cookie.Name == rememberCookieName {
+			remember = append(remember, cookie.Value)
+		}
+	}
+	assert.Equal(t, remember[len(remember)-1], "series:validator")
+}
+
+func TestRememberedSession(t *testing.T) {
+	tests := []struct {
+		name       string
+		cookie     string
+		wantCode   int
+		wantCookie string
+	}{
+		{
+			name:       "Valid token",
+			cookie:     "series:validator",
+			wantCode:   http.StatusOK,
+			wantCookie: "series:rotated",
+		},
+		{
+			name:       "Reused token",
+			cookie:     "series:old-validator",
+			wantCode:   http.StatusSeeOther,
+			wantCookie: "",
+		},
+		{
+			name:       "Unknown series",
+			cookie:     "unknown:validator",
+			wantCode:   http.StatusSeeOther,
+			wantCookie: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			u, err := url.Parse(ts.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ts.Client().Jar.SetCookies(u, []*http.Cookie{{Name: rememberCookieName, Value: tt.cookie}})
+
+			code, header, _ := ts.get(t, "/account/sessions")
+			assert.Equal(t, code, tt.wantCode)
+
+			rs := http.Response{Header: header}
+			for _, cookie := range rs.Cookies() {
+				if cookie.Name == rememberCookieName {
+					assert.Equal(t, cookie.Value, tt.wantCookie)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultCSP is the default Content-Security-Policy. Each {nonce} is replaced with a random value for every
+// request, which the templates add to any <script> and <style> elements, so that they're allowed to run
+// while scripts injected into the page are not. The Ubuntu Mono font is loaded from Google Fonts.
+const defaultCSP = "default-src 'self'; script-src 'self' 'nonce-{nonce}'; " +
+	"style-src 'self' 'nonce-{nonce}' fonts.googleapis.com; font-src fonts.gstatic.com; " +
+	"object-src 'none'; base-uri 'none'; frame-ancestors 'none'; form-action 'self'"
+
+// defaultPermissionsPolicy turns off the browser features which we don't use.
+const defaultPermissionsPolicy = "camera=(), geolocation=(), microphone=(), payment=(), usb=()"
+
+// securityHeaders holds the policy for the security headers which are added to every response. An empty
+// value leaves the header out.
+type securityHeaders struct {
+	csp                   string
+	cspReportOnly         bool
+	hstsMaxAge            time.Duration
+	hstsIncludeSubdomains bool
+	hstsPreload           bool
+	permissionsPolicy     string
+	coop                  string
+	coep                  string
+}
+
+// defaultSecurityHeaders returns the same policy as the default command line flags.
+func defaultSecurityHeaders() securityHeaders {
+	return securityHeaders{
+		csp:                   defaultCSP,
+		hstsMaxAge:            365 * 24 * time.Hour,
+		hstsIncludeSubdomains: true,
+		permissionsPolicy:     defaultPermissionsPolicy,
+		coop:                  "same-origin",
+	}
+}
+
+// validate checks the HSTS settings against the requirements for the browsers' preload lists, which are
+// easy to get wrong and hard to undo.
+func (h securityHeaders) validate() error {
+	if h.hstsPreload && (!h.hstsIncludeSubdomains || h.hstsMaxAge < 365*24*time.Hour) {
+		return errors.New("HSTS preload requires includeSubDomains and a max age of at least one year")
+	}
+	return nil
+}
+
+// hsts returns the value of the Strict-Transport-Security header.
+func (h securityHeaders) hsts() string {
+	value := fmt.Sprintf("max-age=%d", int(h.hstsMaxAge.Seconds()))
+	if h.hstsIncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if h.hstsPreload {
+		value += "; preload"
+	}
+	return value
+}
+
+// commonHeaders adds the security headers to every response, and a CSP nonce to the request context for
+// the templates to use. Violations of the CSP are reported to the /csp-report endpoint. HSTS is only sent
+// over HTTPS, as browsers ignore it otherwise.
+func (app *application) commonHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce, err := newCSPNonce()
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		h := app.securityHeaders
+		if h.csp != "" {
+			csp := strings.ReplaceAll(h.csp, "{nonce}", nonce)
+			if !strings.Contains(csp, "report-uri") {
+				csp += "; report-uri " + app.url("/csp-report")
+			}
+
+			if h.cspReportOnly {
+				w.Header().Set("Content-Security-Policy-Report-Only", csp)
+			} else {
+				w.Header().Set("Content-Security-Policy", csp)
+			}
+		}
+		if h.hstsMaxAge > 0 && app.isHTTPS(r) {
+			w.Header().Set("Strict-Transport-Security", h.hsts())
+		}
+		if h.permissionsPolicy != "" {
+			w.Header().Set("Permissions-Policy", h.permissionsPolicy)
+		}
+		if h.coop != "" {
+			w.Header().Set("Cross-Origin-Opener-Policy", h.coop)
+		}
+		if h.coep != "" {
+			w.Header().Set("Cross-Origin-Embedder-Policy", h.coep)
+		}
+
+		w.Header().Set("Referrer-Policy", "origin-when-cross-origin")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("X-Frame-Options", "deny")
+		w.Header().Set("X-XSS-Protection", "0")
+		w.Header().Set("Server", "Go")
+
+		ctx := context.WithValue(r.Context(), cspNonceContextKey, nonce)
+		serveWithContext(w, r, ctx, next)
+	})
+}
+
+// newCSPNonce returns a random value for the CSP nonce-source expression. It uses the URL-safe base64
+// alphabet without padding, as html/template escapes the "+" and "/" characters in attribute values, and
+// the escaped nonce would no longer match the header (or be removed from the ETag).
+func newCSPNonce() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// cspNonce returns the CSP nonce for the request, or the empty string if it doesn't have one.
+func cspNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceContextKey).(string)
+	return nonce
+}
+
+// cspReportMaxBytes limits the size of the violation reports we accept.
+const cspReportMaxBytes = 64 << 10
+
+// cspViolation holds the interesting parts of a CSP violation report.
+type cspViolation struct {
+	DocumentURL string
+	Directive   string
+	BlockedURL  string
+	SourceFile  string
+	LineNumber  int
+	Disposition string
+}
+
+// cspReport logs the CSP violations which browsers report. Browsers send reports for the report-uri
+// directive with the application/csp-report content type, and for the newer report-to directive with the
+// application/reports+json content type, so we accept both.
+func (app *application) cspReport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, cspReportMaxBytes)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		app.clientError(w, r, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	violations, err := parseCSPReport(contentType, body)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	for _, v := range violations {
+		app.metrics.cspViolations.WithLabelValues(cspDirectiveLabel(v.Directive)).Inc()
+		app.logger.WarnContext(r.Context(), "CSP violation",
+			"document", v.DocumentURL,
+			"directive", v.Directive,
+			"blocked", v.BlockedURL,
+			"source", v.SourceFile,
+			"line", v.LineNumber,
+			"disposition", v.Disposition,
+			"ip", app.clientIP(r),
+		)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// cspDirectives lists the CSP fetch and navigation directives which violations can be reported for.
+var cspDirectives = map[string]bool{
+	"default-src": true, "script-src": true, "script-src-elem": true, "script-src-attr": true,
+	"style-src": true, "style-src-elem": true, "style-src-attr": true, "img-src": true, "font-src": true,
+	"connect-src": true, "media-src": true, "object-src": true, "frame-src": true, "child-src": true,
+	"worker-src": true, "manifest-src": true, "base-uri": true, "form-action": true, "frame-ancestors": true,
+}
+
+// cspDirectiveLabel returns the directive for the violations metric. Reports come from the browser, so we
+// don't trust them to only contain real directive names, which would make the number of label values
+// unbounded.
+func cspDirectiveLabel(directive string) string {
+	if cspDirectives[directive] {
+		return directive
+	}
+	return "other"
+}
+
+// parseCSPReport parses a violation report in either of the formats that browsers use.
+func parseCSPReport(contentType string, body []byte) ([]cspViolation, error) {
+	switch contentType {
+	case "application/csp-report", "application/json":
+		var report struct {
+			Body struct {
+				DocumentURI        string `json:"document-uri"`
+				ViolatedDirective  string `json:"violated-directive"`
+				EffectiveDirective string `json:"effective-directive"`
+				BlockedURI         string `json:"blocked-uri"`
+				SourceFile         string `json:"source-file"`
+				LineNumber         int    `json:"line-number"`
+				Disposition        string `json:"disposition"`
+			} `json:"csp-report"`
+		}
+		err := json.Unmarshal(body, &report)
+		if err != nil {
+			return nil, err
+		}
+
+		b := report.Body
+		directive := b.EffectiveDirective
+		if directive == "" {
+			directive, _, _ = strings.Cut(b.ViolatedDirective, " ")
+		}
+
+		return []cspViolation{{
+			DocumentURL: b.DocumentURI,
+			Directive:   directive,
+			BlockedURL:  b.BlockedURI,
+			SourceFile:  b.SourceFile,
+			LineNumber:  b.LineNumber,
+			Disposition: b.Disposition,
+		}}, nil
+
+	case "application/reports+json":
+		var reports []struct {
+			Type string `json:"type"`
+			Body struct {
+				DocumentURL        string `json:"documentURL"`
+				EffectiveDirective string `json:"effectiveDirective"`
+				BlockedURL         string `json:"blockedURL"`
+				SourceFile         string `json:"sourceFile"`
+				LineNumber         int    `json:"lineNumber"`
+				Disposition        string `json:"disposition"`
+			} `json:"body"`
+		}
+		err := json.Unmarshal(body, &reports)
+		if err != nil {
+			return nil, err
+		}
+
+		var violations []cspViolation
+		for _, report := range reports {
+			if report.Type != "csp-violation" {
+				continue
+			}
+			b := report.Body
+			violations = append(violations, cspViolation{
+				DocumentURL: b.DocumentURL,
+				Directive:   b.EffectiveDirective,
+				BlockedURL:  b.BlockedURL,
+				SourceFile:  b.SourceFile,
+				LineNumber:  b.LineNumber,
+				Disposition: b.Disposition,
+			})
+		}
+		return violations, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+}
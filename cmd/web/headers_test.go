@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+func TestSecurityHeadersPolicy(t *testing.T) {
+	app := newTestApplication(t)
+	app.securityHeaders = securityHeaders{
+		csp:                   "default-src 'self'; script-src 'nonce-{nonce}'",
+		cspReportOnly:         true,
+		hstsMaxAge:            2 * 365 * 24 * time.Hour,
+		hstsIncludeSubdomains: true,
+		hstsPreload:           true,
+		coep:                  "require-corp",
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	rr := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	app.commonHeaders(next).ServeHTTP(rr, r)
+
+	rs := rr.Result()
+	assert.Equal(t, rs.Header.Get("Content-Security-Policy"), "")
+	assert.StringContains(t, rs.Header.Get("Content-Security-Policy-Report-Only"), "script-src 'nonce-")
+	assert.StringContains(t, rs.Header.Get("Content-Security-Policy-Report-Only"), "; report-uri /csp-report")
+	assert.Equal(t, rs.Header.Get("Strict-Transport-Security"), "max-age=63072000; includeSubDomains; preload")
+	assert.Equal(t, rs.Header.Get("Cross-Origin-Embedder-Policy"), "require-corp")
+	assert.Equal(t, rs.Header.Get("Permissions-Policy"), "")
+	assert.Equal(t, rs.Header.Get("Cross-Origin-Opener-Policy"), "")
+}
+
+func TestSecurityHeadersValidate(t *testing.T) {
+	h := defaultSecurityHeaders()
+	assert.NilError(t, h.validate())
+
+	h.hstsPreload = true
+	assert.NilError(t, h.validate())
+
+	h.hstsMaxAge = time.Hour
+	if h.validate() == nil {
+		t.Error("expected an error for preload with a short max age")
+	}
+}
+
+func TestCSPNoncePerRequest(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	nonces := map[string]bool{}
+	for range 2 {
+		_, header, body := ts.get(t, "/")
+
+		csp := header.Get("Content-Security-Policy")
+		_, nonce, _ := strings.Cut(csp, "'nonce-")
+		nonce, _, _ = strings.Cut(nonce, "'")
+		if nonce == "" {
+			t.Fatalf("no nonce in CSP %q", csp)
+		}
+		assert.StringContains(t, body, `nonce="`+nonce+`"`)
+		nonces[nonce] = true
+	}
+	assert.Equal(t, len(nonces), 2)
+}
+
+func TestCSPReport(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentType   string
+		body          string
+		wantCode      int
+		wantDirective string
+	}{
+		{
+			name:          "report-uri",
+			contentType:   "application/csp-report",
+			body:          `{"csp-report": {"document-uri": "https://example.com/", "violated-directive": "script-src-elem 'self'", "blocked-uri": "inline"}}`,
+			wantCode:      http.StatusNoContent,
+			wantDirective: "script-src-elem",
+		},
+		{
+			name:          "report-to",
+			contentType:   "application/reports+json",
+			body:          `[{"type": "csp-violation", "body": {"documentURL": "https://example.com/", "effectiveDirective": "img-src", "blockedURL": "https://evil.example/x.png"}}]`,
+			wantCode:      http.StatusNoContent,
+			wantDirective: "img-src",
+		},
+		{
+			name:          "Unknown directive",
+			contentType:   "application/csp-report",
+			body:          `{"csp-report": {"effective-directive": "made-up"}}`,
+			wantCode:      http.StatusNoContent,
+			wantDirective: "other",
+		},
+		{
+			name:        "Invalid JSON",
+			contentType: "application/csp-report",
+			body:        `{"csp-report": `,
+			wantCode:    http.StatusBadRequest,
+		},
+		{
+			name:        "Wrong content type",
+			contentType: "text/plain",
+			body:        `hello`,
+			wantCode:    http.StatusBadRequest,
+		},
+		{
+			name:        "Too large",
+			contentType: "application/csp-report",
+			body:        strings.Repeat(" ", cspReportMaxBytes+1),
+			wantCode:    http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			rs, err := ts.Client().Post(ts.URL+"/csp-report", tt.contentType, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs.Body.Close()
+
+			assert.Equal(t, rs.StatusCode, tt.wantCode)
+
+			if tt.wantDirective != "" {
+				violations := testutil.ToFloat64(app.metrics.cspViolations.WithLabelValues(tt.wantDirective))
+				assert.Equal(t, violations, 1.0)
+			}
+		})
+	}
+}
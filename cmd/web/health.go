@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// readinessTimeout is how long each readiness check can take before it is treated as failed. Load balancers
+// usually time out their health checks after a few seconds, and we want to report a failure before they do.
+const readinessTimeout = 2 * time.Second
+
+// pinger is implemented by *sql.DB. It lets tests stand in for the database in the readiness checks.
+type pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+type healthStatus struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// healthz reports whether the process is alive. It doesn't check any dependencies, as restarting the
+// process won't fix a database outage.
+func (app *application) healthz(w http.ResponseWriter, r *http.Request) {
+	app.writeHealth(w, r, http.StatusOK, healthStatus{Status: "ok"})
+}
+
+// readyz reports whether the application is ready to serve traffic: the database and session store are
+// reachable, the template cache is loaded and the server isn't shutting down. Each check is reported
+// separately. The reasons for any failures are logged rather than included in the response, as this
+// endpoint is publicly reachable.
+func (app *application) readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]func(ctx context.Context) error{
+		"database":      app.checkDatabase,
+		"session_store": app.checkSessionStore,
+		"templates":     app.checkTemplates,
+		"shutdown":      app.checkShutdown,
+	}
+
+	health := healthStatus{Status: "ok", Checks: map[string]string{}}
+	status := http.StatusOK
+
+	for name, check := range checks {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		err := check(ctx)
+		cancel()
+
+		if err != nil {
+			app.logger.WarnContext(r.Context(), "readiness check failed", "check", name, "error", err.Error())
+			health.Checks[name] = "fail"
+			health.Status = "fail"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+
+		health.Checks[name] = "ok"
+	}
+
+	app.writeHealth(w, r, status, health)
+}
+
+func (app *application) writeHealth(w http.ResponseWriter, r *http.Request, status int, health healthStatus) {
+	js, err := json.Marshal(health)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// Health checks must always reflect the current state, so never let them be cached.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+func (app *application) checkDatabase(ctx context.Context) error {
+	if app.db == nil {
+		return errors.New("no database configured")
+	}
+
+	return app.db.PingContext(ctx)
+}
+
+// checkSessionStore looks up a session token which doesn't exist. This makes a round trip to the store
+// without changing anything. The scs stores don't take a context, so we give up waiting when ctx is done.
+func (app *application) checkSessionStore(ctx context.Context) error {
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, err := app.sessionManager.Store.Find("readyz")
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (app *application) checkTemplates(ctx context.Context) error {
+	if len(app.templateCache) == 0 {
+		return errors.New("template cache is empty")
+	}
+
+	return nil
+}
+
+func (app *application) checkShutdown(ctx context.Context) error {
+	if app.shuttingDown.Load() {
+		return errors.New("server is shutting down")
+	}
+
+	return nil
+}
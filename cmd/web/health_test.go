@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+// fakeDB stands in for the database connection pool in the readiness checks.
+type fakeDB struct {
+	err error
+}
+
+func (db *fakeDB) PingContext(ctx context.Context) error {
+	return db.err
+}
+
+func TestHealthz(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, body := ts.get(t, "/healthz")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "application/json")
+	assert.Equal(t, body, `{"status":"ok"}`)
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name      string
+		setup     func(app *application)
+		wantCode  int
+		wantCheck string
+	}{
+		{
+			name:     "Ready",
+			setup:    func(app *application) {},
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "Database down",
+			setup: func(app *application) {
+				app.db = &fakeDB{err: errors.New("connection refused")}
+			},
+			wantCode:  http.StatusServiceUnavailable,
+			wantCheck: "database",
+		},
+		{
+			name: "No templates",
+			setup: func(app *application) {
+				app.templateCache = nil
+			},
+			wantCode:  http.StatusServiceUnavailable,
+			wantCheck: "templates",
+		},
+		{
+			name: "Shutting down",
+			setup: func(app *application) {
+				app.shuttingDown.Store(true)
+			},
+			wantCode:  http.StatusServiceUnavailable,
+			wantCheck: "shutdown",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.db = &fakeDB{}
+			tt.setup(app)
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			code, _, body := ts.get(t, "/readyz")
+			assert.Equal(t, code, tt.wantCode)
+
+			var health healthStatus
+			err := json.Unmarshal([]byte(body), &health)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, name := range []string{"database", "session_store", "templates", "shutdown"} {
+				want := "ok"
+				if name == tt.wantCheck {
+					want = "fail"
+				}
+				assert.Equal(t, health.Checks[name], want)
+			}
+		})
+	}
+}
@@ -2,11 +2,20 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
+	"strings"
 	"time"
 
 	"github.com/justinas/nosurf"
+	"github.com/vishal-rfx/snippetbox/internal/models"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // serverError helper writes a log entry at Error level (including the request method and request URI as attributes),
@@ -17,7 +26,7 @@ func (app *application) serverError(w http.ResponseWriter, r *http.Request, err
 		uri = r.RequestURI
 	)
 
-	app.logger.Error(err.Error(), "method", method, "uri", uri)
+	app.logger.ErrorContext(r.Context(), err.Error(), "method", method, "uri", uri)
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
@@ -30,22 +39,7 @@ func (app *application) clientError(w http.ResponseWriter,r *http.Request, statu
 
 
 func (app *application) render(w http.ResponseWriter, r *http.Request, status int, page string, data templateData){
-	// Retrieve the appropriate template set from the cache based on the page name
-	// like ('home.html.tmpl'). If no entry exists in the cache with the provided name, then create a new error and call
-	// the serverError() helper method
-	ts, ok := app.templateCache[page]
-	if !ok {
-		err := fmt.Errorf("the template %s does not exist", page)
-		app.serverError(w, r, err)
-		return
-	}
-
-	// Initialize a new buffer.
-	buf := new(bytes.Buffer)
-
-	// Write the template to the buffer, instead of straight to the http.ResponseWriter. If there's an error, call our
-	// serverError() helper and then return
-	err := ts.ExecuteTemplate(buf, "base", data)
+	buf, err := app.executePage(r, page, data)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
@@ -60,6 +54,32 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, status in
 
 }
 
+// executePage executes the template for a page into a buffer.
+func (app *application) executePage(r *http.Request, page string, data templateData) (*bytes.Buffer, error) {
+	// Retrieve the appropriate template set from the cache based on the page name
+	// like ('home.html.tmpl'). If no entry exists in the cache with the provided name, then return an error.
+	ts, ok := app.templateCache[page]
+	if !ok {
+		return nil, fmt.Errorf("the template %s does not exist", page)
+	}
+
+	// Initialize a new buffer.
+	buf := new(bytes.Buffer)
+
+	// Write the template to the buffer, instead of straight to the http.ResponseWriter, so that we can still
+	// send an error response if there's an error.
+	_, span := tracer.Start(r.Context(), "render", trace.WithAttributes(attribute.String("template", page)))
+	start := time.Now()
+	err := ts.ExecuteTemplate(buf, "base", data)
+	app.metrics.renderDuration.WithLabelValues(page).Observe(time.Since(start).Seconds())
+	span.End()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
 // newTemplateData returns a templateData struct initialized with the current year. Note that we're not 
 func (app *application) newTemplateData(r *http.Request) templateData{
 	return templateData{
@@ -67,9 +87,33 @@ func (app *application) newTemplateData(r *http.Request) templateData{
 		Flash: app.sessionManager.PopString(r.Context(), "flash"),
 		IsAuthenticated: app.isAuthenticated(r),
 		CSRFToken: nosurf.Token(r),
+		CSPNonce: cspNonce(r),
+		OIDCProviders: app.oidcProviders,
+		IsModerator: app.authenticatedUser(r).Role.Allows(models.RoleModerator),
+		IsAdmin: app.authenticatedUser(r).Role.Allows(models.RoleAdmin),
+		AuthenticatedUserID: app.authenticatedUser(r).ID,
+		IsClientCertLogin: app.isClientCertLogin(r),
 	}
 }
 
+// authenticatedUser returns the details of the user who made the request, or the zero User if they aren't
+// authenticated.
+func (app *application) authenticatedUser(r *http.Request) models.User {
+	user, ok := r.Context().Value(authenticatedUserContextKey).(models.User)
+	if !ok {
+		return models.User{}
+	}
+
+	return user
+}
+
+// isClientCertLogin returns true if the user who made the request was logged in by their client certificate,
+// rather than by a session.
+func (app *application) isClientCertLogin(r *http.Request) bool {
+	fromClientCert, _ := r.Context().Value(clientCertLoginContextKey).(bool)
+	return fromClientCert
+}
+
 func (app *application) isAuthenticated(r *http.Request) bool {
 	isAuthenticated, ok := r.Context().Value(isAuthenticatedContextKey).(bool)
 	if !ok {
@@ -78,4 +122,221 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 
 	return isAuthenticated
 
-}
\ No newline at end of file
+}
+
+// startAuthenticatedSession logs a user in on the current session. It renews the session token, stores the
+// user ID in the session and records where the user logged in from, so that it shows up on their active
+// sessions page and can be revoked from another device.
+func (app *application) startAuthenticatedSession(r *http.Request, userID int) error {
+	// Use the RenewToken method on the current session to change the session ID. It's good practice to
+	// generate a new seesion ID when the authentication state or privilege levels changes for the user
+	// (e.g. login and logout operation)
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+
+	// Add the ID of the current user to the session, so that they are now logged in
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", userID)
+
+	token := app.sessionManager.Token(r.Context())
+	return app.userSessions.Insert(r.Context(), token, userID, r.UserAgent(), app.clientIP(r), app.sessionManager.Deadline(r.Context()))
+}
+
+// revokeSession deletes a session from the session store, which immediately logs out whoever holds it, and
+// then removes the metadata we recorded for it along with any remember token that could re-establish it.
+func (app *application) revokeSession(ctx context.Context, token string) error {
+	err := app.sessionManager.Store.Delete(token)
+	if err != nil {
+		return err
+	}
+
+	err = app.rememberTokens.DeleteBySessionToken(ctx, token)
+	if err != nil {
+		return err
+	}
+
+	return app.userSessions.Delete(ctx, token)
+}
+
+// revokeAllSessions revokes every active session belonging to a user, apart from the session with the token
+// given in except (pass an empty string to revoke them all).
+func (app *application) revokeAllSessions(ctx context.Context, userID int, except string) error {
+	sessions, err := app.userSessions.GetAll(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.Token == except {
+			continue
+		}
+
+		err = app.revokeSession(ctx, session.Token)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// purgeExpiredSessions deletes the metadata for expired sessions every interval, until stop is closed. The
+// session store removes expired sessions by itself, but doesn't know about the rows we keep alongside them.
+func purgeExpiredSessions(userSessions models.UserSessionModelInterface, interval time.Duration, logger *slog.Logger, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			n, err := userSessions.DeleteExpired(context.Background())
+			if err != nil {
+				logger.Error("deleting expired sessions", "error", err.Error())
+				continue
+			}
+			if n > 0 {
+				logger.Info("Deleted expired sessions", "count", n)
+			}
+		}
+	}
+}
+
+// The remember me cookie holds a remember token's series and validator, separated by a colon.
+const rememberCookieName = "remember_token"
+
+func (app *application) setRememberCookie(w http.ResponseWriter, r *http.Request, token models.RememberToken) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberCookieName,
+		Value:    token.Series + ":" + token.Validator,
+		Path:     cookiePath(app.basePath),
+		Expires:  token.Expires,
+		MaxAge:   int(time.Until(token.Expires).Seconds()),
+		HttpOnly: true,
+		Secure:   app.isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (app *application) clearRememberCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     rememberCookieName,
+		Value:    "",
+		Path:     cookiePath(app.basePath),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   app.isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// readRememberCookie returns the series and validator from the remember me cookie, if the request has one.
+func readRememberCookie(r *http.Request) (series, validator string, ok bool) {
+	cookie, err := r.Cookie(rememberCookieName)
+	if err != nil {
+		return "", "", false
+	}
+
+	series, validator, ok = strings.Cut(cookie.Value, ":")
+	if !ok || series == "" || validator == "" {
+		return "", "", false
+	}
+
+	return series, validator, true
+}
+
+// restoreRememberedSession checks the request for a remember me cookie and, if it holds a valid token, logs
+// the user back in on a fresh session and rotates the token. It returns the ID of the user who was logged
+// in, or 0 if there was no valid token.
+func (app *application) restoreRememberedSession(w http.ResponseWriter, r *http.Request) (int, error) {
+	series, validator, ok := readRememberCookie(r)
+	if !ok {
+		return 0, nil
+	}
+
+	token, err := app.rememberTokens.Rotate(r.Context(), series, validator)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.clearRememberCookie(w, r)
+			return 0, nil
+		case errors.Is(err, models.ErrRememberTokenReused):
+			// The token has been stolen. The model has already deleted every remember token for the user,
+			// so log them out everywhere else too.
+			app.metrics.loginsFailed.WithLabelValues("remember", "token_reused").Inc()
+			app.logger.WarnContext(r.Context(), "remember token reused, revoking all sessions", "userID", token.UserID, "ip", app.clientIP(r))
+			app.clearRememberCookie(w, r)
+			return 0, app.revokeAllSessions(r.Context(), token.UserID, "")
+		default:
+			return 0, err
+		}
+	}
+
+	err = app.startAuthenticatedSession(r, token.UserID)
+	if err != nil {
+		return 0, err
+	}
+	app.metrics.loginsSucceeded.WithLabelValues("remember").Inc()
+
+	err = app.rememberTokens.SetSessionToken(r.Context(), series, app.sessionManager.Token(r.Context()))
+	if err != nil {
+		return 0, err
+	}
+
+	// The validator is only empty if a parallel request has just rotated the token, in which case that
+	// request has already sent the new cookie.
+	if token.Validator != "" {
+		app.setRememberCookie(w, r, token)
+	}
+
+	return token.UserID, nil
+}
+
+// clientIP returns the IP address of the client which made the request, without the port number. If the
+// request came from one of our trusted proxies, we use the Forwarded or X-Forwarded-For header instead. We walk
+// the header from right to left (as each proxy appends the address it received the request from) and return
+// the first address which isn't a trusted proxy. Anything to the left of that could have been forged by the
+// client.
+func (app *application) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if !app.isTrustedProxy(ip) {
+		return ip
+	}
+
+	forwarded := forwardedFor(r)
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := forwarded[i]
+		if _, err := netip.ParseAddr(addr); err != nil {
+			break
+		}
+
+		ip = addr
+		if !app.isTrustedProxy(ip) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// isTrustedProxy returns true if ip is within one of the trusted proxy address ranges.
+func (app *application) isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+
+	for _, prefix := range app.trustedProxies {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
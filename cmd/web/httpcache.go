@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// snippetMaxAge is the longest that browsers can reuse a snippet page without checking back with us.
+const snippetMaxAge = 5 * time.Minute
+
+// renderCacheable renders a page which browsers can cache, and which supports conditional requests with
+// If-None-Match and If-Modified-Since. The ETag is a hash of the rendered page, so it changes whenever
+// anything on the page does. Browsers can reuse the page for up to snippetMaxAge, or until expires if that
+// is sooner. Pages for authenticated users are never cached, as they contain a CSRF token and shouldn't be
+// left behind on shared computers.
+func (app *application) renderCacheable(w http.ResponseWriter, r *http.Request, page string, data templateData, lastModified, expires time.Time) {
+	if app.isAuthenticated(r) {
+		w.Header().Set("Cache-Control", "no-store")
+		app.render(w, r, http.StatusOK, page, data)
+		return
+	}
+
+	buf, err := app.executePage(r, page, data)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	maxAge := min(snippetMaxAge, time.Until(expires))
+	if maxAge >= time.Second {
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(maxAge.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+
+	// The page depends on whether the user is logged in, so caches must take the cookies into account. We
+	// don't need to add Vary: Cookie ourselves, as the session manager and noSurf already do. The page
+	// contains the CSP nonce, which is different every time, so we leave it out of the ETag.
+	w.Header().Set("ETag", hashETag(bytes.ReplaceAll(buf.Bytes(), []byte(data.CSPNonce), nil)))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	// ServeContent checks the conditional request headers against the ETag and lastModified, and sends a
+	// 304 Not Modified response if the browser's copy is still current.
+	http.ServeContent(notModifiedWriter{w}, r, "", lastModified, bytes.NewReader(buf.Bytes()))
+}
+
+// notModifiedWriter removes the CSP headers from 304 Not Modified responses. Browsers update the headers of
+// their cached copy of a page with the ones in a 304 response, and the cached page still has the nonce of
+// the CSP it was first sent with.
+type notModifiedWriter struct {
+	http.ResponseWriter
+}
+
+func (w notModifiedWriter) WriteHeader(status int) {
+	if status == http.StatusNotModified {
+		w.Header().Del("Content-Security-Policy")
+		w.Header().Del("Content-Security-Policy-Report-Only")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w notModifiedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// hashETag returns a strong ETag for a response body.
+func hashETag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
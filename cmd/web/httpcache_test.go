@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+// getWithHeaders makes a GET request to the test server with extra request headers, given as pairs of
+// names and values, and returns the response status code and headers.
+func (ts *testServer) getWithHeaders(t *testing.T, urlPath string, headers ...string) (int, http.Header) {
+	req, err := http.NewRequest(http.MethodGet, ts.URL+urlPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+
+	return rs.StatusCode, rs.Header
+}
+
+func TestSnippetViewConditional(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header := ts.getWithHeaders(t, "/snippet/view/1")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Cache-Control"), "private, no-cache")
+	assert.StringContains(t, strings.Join(header.Values("Vary"), ", "), "Cookie")
+
+	etag := header.Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag header")
+	}
+
+	// The 304 response leaves out the CSP, so that the browser keeps the one which matches the nonce in its
+	// cached copy.
+	code, header304 := ts.getWithHeaders(t, "/snippet/view/1", "If-None-Match", etag)
+	assert.Equal(t, code, http.StatusNotModified)
+	assert.Equal(t, header304.Get("Content-Security-Policy"), "")
+
+	code, _ = ts.getWithHeaders(t, "/snippet/view/1", "If-None-Match", `"stale"`)
+	assert.Equal(t, code, http.StatusOK)
+
+	code, _ = ts.getWithHeaders(t, "/snippet/view/1", "If-Modified-Since", header.Get("Last-Modified"))
+	assert.Equal(t, code, http.StatusNotModified)
+
+	// Pages for authenticated users are never cached.
+	ts.login(t)
+	code, header = ts.getWithHeaders(t, "/snippet/view/1", "If-None-Match", etag)
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Cache-Control"), "no-store")
+	assert.Equal(t, header.Get("ETag"), "")
+}
+
+func TestStaticETags(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header := ts.getWithHeaders(t, "/static/css/main.css", "Accept-Encoding", "identity")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("ETag"), `"`+app.staticAssets["static/css/main.css"].hash[:32]+`"`)
+
+	code, _ = ts.getWithHeaders(t, "/static/css/main.css", "Accept-Encoding", "identity", "If-None-Match", header.Get("ETag"))
+	assert.Equal(t, code, http.StatusNotModified)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newLogger creates the application's structured logger, writing to w in either the "text" or "json"
+// format. Records logged with a request context (like app.logger.InfoContext(r.Context(), ...)) are tagged
+// with the request ID and trace ID, so that all of the log entries for a request can be found together.
+func newLogger(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{
+		Level:     level,
+		AddSource: true,
+	}
+
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	return slog.New(contextHandler{handler}), nil
+}
+
+// contextHandler is a slog.Handler which adds the request ID and trace ID from the context to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id, ok := ctx.Value(requestIDContextKey).(string); ok {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		record.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// validRequestID matches the request IDs we're willing to accept from clients and proxies. Anything else is
+// replaced, so that a client can't fill the logs with arbitrary text.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestID is a middleware which gives every request an ID. If the request already has a valid
+// X-Request-ID header (for example, because a load balancer has set one) then that ID is used, otherwise a
+// random one is generated. The ID is stored in the request context and sent back in the response headers.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		serveWithContext(w, r, ctx, next)
+	})
+}
+
+// newRequestID returns a random 16 byte ID, hex encoded.
+func newRequestID() string {
+	b := make([]byte, 16)
+	// rand.Read never returns an error; it crashes the program instead if the OS can't provide randomness.
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestLog holds details about a request which are only discovered by middleware further down the chain,
+// but which logRequest needs when it writes the access log entry. logRequest stores a pointer to it in the
+// request context, and later middleware fill it in.
+type requestLog struct {
+	userID int
+}
+
+// setRequestLogUser records the authenticated user for the access log entry of a request.
+func setRequestLogUser(r *http.Request, userID int) {
+	if l, ok := r.Context().Value(requestLogContextKey).(*requestLog); ok {
+		l.userID = userID
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		wantSame bool
+	}{
+		{
+			name: "No header",
+		},
+		{
+			name:     "Valid header",
+			header:   "lb-7f3a9c.42",
+			wantSame: true,
+		},
+		{
+			name:   "Invalid header",
+			header: "two words\n",
+		},
+	}
+
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+
+			var fromContext string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fromContext, _ = r.Context().Value(requestIDContextKey).(string)
+			})
+
+			rr := httptest.NewRecorder()
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				r.Header.Set("X-Request-ID", tt.header)
+			}
+
+			app.requestID(next).ServeHTTP(rr, r)
+
+			id := rr.Header().Get("X-Request-ID")
+			assert.Equal(t, fromContext, id)
+
+			if tt.wantSame {
+				assert.Equal(t, id, tt.header)
+			} else {
+				assert.Equal(t, generated.MatchString(id), true)
+			}
+		})
+	}
+}
+
+func TestLogRequest(t *testing.T) {
+	app := newTestApplication(t)
+
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "json", slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.logger = logger
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+	buf.Reset()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/snippet/view/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Request-ID", "test-request")
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+
+	var entry struct {
+		Msg       string  `json:"msg"`
+		RequestID string  `json:"request_id"`
+		Route     string  `json:"route"`
+		Status    int     `json:"status"`
+		Bytes     int     `json:"bytes"`
+		Duration  float64 `json:"duration"`
+		UserID    int     `json:"user_id"`
+	}
+
+	err = json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatalf("parsing log entry %q: %s", buf.String(), err)
+	}
+
+	assert.Equal(t, entry.Msg, "Handled request")
+	assert.Equal(t, entry.RequestID, "test-request")
+	assert.Equal(t, entry.Route, "GET /snippet/view/{id}")
+	assert.Equal(t, entry.Status, http.StatusOK)
+	assert.Equal(t, entry.Bytes > 0, true)
+	assert.Equal(t, entry.Duration > 0, true)
+	assert.Equal(t, entry.UserID, 1)
+}
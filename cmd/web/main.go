@@ -1,20 +1,33 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/alexedwards/scs/mysqlstore"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
 	_ "github.com/go-sql-driver/mysql"
+	"github.com/vishal-rfx/snippetbox/internal/cache"
 	"github.com/vishal-rfx/snippetbox/internal/models"
+	"github.com/vishal-rfx/snippetbox/internal/ratelimit"
+	"github.com/vishal-rfx/snippetbox/ui"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 // Define an application struct to hold the application-wide dependencies for the
@@ -23,9 +36,24 @@ type application struct {
 	logger *slog.Logger
 	snippets models.SnippetModelInterface
 	users models.UserModelInterface
+	userSessions models.UserSessionModelInterface
+	rememberTokens models.RememberTokenModelInterface
+	comments models.CommentModelInterface
+	commentModerators []commentModerator
 	templateCache map[string]*template.Template
 	formDecoder *form.Decoder
 	sessionManager *scs.SessionManager
+	rememberLifetime time.Duration
+	archiveWriteTimeout time.Duration
+	oidcProviders []*oidcProvider
+	trustedProxies []netip.Prefix
+	basePath string
+	rateLimiter ratelimit.Store
+	metrics *metrics
+	db pinger
+	staticAssets map[string]*staticAsset
+	securityHeaders securityHeaders
+	shuttingDown atomic.Bool
 }
 
 
@@ -41,14 +69,145 @@ func main(){
 
 	// Define a new command line flag for the MySQL DSN string
 	dsn := flag.String("dsn", "web:vishal@/snippetbox?parseTime=true", "MySQL data source name")
+
+	// Define command line flags for the database connection pool, and for how long each model method can
+	// spend on its queries. The query timeout should be shorter than the server's WriteTimeout, so that we
+	// can still send an error page when the database is slow.
+	var dbPool dbPoolConfig
+	flag.IntVar(&dbPool.maxOpenConns, "db-max-open-conns", 25, "Maximum number of open database connections")
+	flag.IntVar(&dbPool.maxIdleConns, "db-max-idle-conns", 25, "Maximum number of idle database connections")
+	flag.DurationVar(&dbPool.connMaxLifetime, "db-conn-max-lifetime", time.Hour, "Maximum lifetime of a database connection")
+	flag.DurationVar(&dbPool.connMaxIdleTime, "db-conn-max-idle-time", 15*time.Minute, "Maximum time a database connection can be idle")
+	queryTimeout := flag.Duration("db-query-timeout", 5*time.Second, "Maximum time for the database queries of a single operation (0 to disable)")
+
+	// Define command line flags for the in-memory snippet cache, which saves a database query for each view
+	// of a popular snippet. Cached snippets can be up to the TTL out of date after they're deleted by another
+	// instance of the application.
+	snippetCacheSize := flag.Int("snippet-cache-size", 1000, "Maximum number of entries in the snippet cache (0 to disable)")
+	snippetCacheTTL := flag.Duration("snippet-cache-ttl", time.Minute, "Maximum time to cache snippets for")
+
+	// Define command line flags for comment moderation. Comments with more than the maximum number of links
+	// are held for a moderator to approve, and comments containing any of the blocklisted words are
+	// rejected. The comments on expired snippets are deleted every purge interval.
+	commentMaxLinks := flag.Int("comment-max-links", 3, "Maximum number of links in a comment before it is held for moderation (negative to disable)")
+	commentBlocklist := flag.String("comment-blocklist", "", "Comma-separated list of words which aren't allowed in comments")
+	commentPurgeInterval := flag.Duration("comment-purge-interval", time.Hour, "How often to delete the comments on expired snippets (0 to disable)")
+
+	// Define command line flags for the session settings. Sessions expire after the absolute lifetime, or
+	// earlier if they are inactive for longer than the idle timeout (a value of 0 disables the idle timeout).
+	// Users who tick "remember me" when logging in are transparently logged back in for up to the remember
+	// lifetime. The records of expired sessions are deleted every purge interval.
+	sessionLifetime := flag.Duration("session-lifetime", 12*time.Hour, "Maximum lifetime of a session")
+	sessionIdleTimeout := flag.Duration("session-idle-timeout", 0, "Idle timeout for sessions (0 to disable)")
+	rememberLifetime := flag.Duration("remember-lifetime", 30*24*time.Hour, "Lifetime of remember me tokens")
+	sessionPurgeInterval := flag.Duration("session-purge-interval", time.Hour, "How often to delete the records of expired sessions (0 to disable)")
+
+	// Archives can take much longer to send than a page, so they get their own write timeout in place of the
+	// server's.
+	archiveWriteTimeout := flag.Duration("archive-write-timeout", 5*time.Minute, "Write timeout for snippet archives and account exports (0 to disable)")
+
+	// Define command line flags for the addresses of any reverse proxies in front of the application, whose
+	// X-Forwarded-For headers we trust, and for whether requests are rate limited.
+	trustedProxiesList := flag.String("trusted-proxies", "", "Comma-separated list of trusted proxy IPs or CIDR ranges")
+	rateLimit := flag.Bool("ratelimit", true, "Enable per-client rate limiting")
+
+	// Define a command line flag for the network address of the admin listener, which serves the Prometheus
+	// metrics at /metrics. It's kept separate from the main server so that it isn't exposed to the internet.
+	metricsAddr := flag.String("metrics-addr", "localhost:4001", "Admin HTTP network address for metrics (empty to disable)")
+
+	// Define a command line flag for the path to a JSON file listing the OpenID Connect providers that users
+	// can log in with. If it's not set, only local accounts can be used.
+	oidcProvidersPath := flag.String("oidc-providers", "", "Path to OpenID Connect providers JSON file")
+
+	// Define command line flags for the authentication backend used by the login form. With the ldap backend,
+	// users log in with their directory credentials and a local user record is created for them on their
+	// first login. Local accounts can still log in when the directory doesn't know about them (or can't be
+	// reached) if -ldap-local-fallback is set, which is useful for break-glass admin accounts.
+	authBackend := flag.String("auth", "local", "Authentication backend for the login form (local or ldap)")
+	ldapURL := flag.String("ldap-url", "ldap://localhost:389", "LDAP server URL (ldap:// or ldaps://)")
+	ldapStartTLS := flag.Bool("ldap-starttls", false, "Use StartTLS to upgrade ldap:// connections")
+	ldapCAFile := flag.String("ldap-ca-file", "", "PEM file of CA certificates to verify the LDAP server with")
+	ldapBindDN := flag.String("ldap-bind-dn", "", "DN of the LDAP service account used for searches")
+	ldapBindPassword := flag.String("ldap-bind-password", "", "Password of the LDAP service account")
+	ldapBaseDN := flag.String("ldap-base-dn", "", "Base DN to search for users and groups")
+	ldapUserFilter := flag.String("ldap-user-filter", "(&(objectClass=person)(mail=%s))", "LDAP filter to find a user by email")
+	ldapGroupFilter := flag.String("ldap-group-filter", "", "LDAP filter which must match a group containing the user DN (optional)")
+	ldapNameAttr := flag.String("ldap-name-attr", "cn", "LDAP attribute holding the user's name")
+	ldapEmailAttr := flag.String("ldap-email-attr", "mail", "LDAP attribute holding the user's email address")
+	ldapLocalFallback := flag.Bool("ldap-local-fallback", false, "Allow local accounts to log in when not found in LDAP")
+
+	// Define a command line flag for whether the CSS and JavaScript files are minified when they're loaded.
+	minifyAssets := flag.Bool("minify-assets", true, "Minify CSS and JavaScript static assets")
+
+	// Define command line flags for graceful shutdown. During the delay the server keeps serving requests,
+	// but reports that it isn't ready, so that load balancers can stop routing traffic to it. Then it waits
+	// up to the timeout for requests in flight to complete.
+	shutdownDelay := flag.Duration("shutdown-delay", 5*time.Second, "Time to fail readiness checks before shutting down")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 20*time.Second, "Maximum time to wait for requests in flight when shutting down")
+
+	// Define command line flags for the log output. The json format is easier for log aggregators to parse.
+	logFormat := flag.String("log-format", "text", "Log format (text or json)")
+	var logLevel slog.Level
+	flag.TextVar(&logLevel, "log-level", slog.LevelDebug, "Minimum log level (DEBUG, INFO, WARN or ERROR)")
+
+	// Define command line flags for OpenTelemetry tracing. Spans can be sent to a collector with OTLP over HTTP,
+	// or written to stdout when developing.
+	traceExporter := flag.String("trace-exporter", "none", "Trace exporter (none, stdout or otlp)")
+	traceEndpoint := flag.String("trace-endpoint", "http://localhost:4318/v1/traces", "OTLP/HTTP traces endpoint URL")
+	traceSampleRatio := flag.Float64("trace-sample-ratio", 1, "Fraction of new traces to sample (0 to 1)")
+
+	// Define command line flags for TLS. The certificate is reloaded when the files change, or when the
+	// process receives SIGHUP, so that renewed certificates are picked up without a restart.
+	var tlsOpts tlsOptions
+	flag.StringVar(&tlsOpts.certFile, "tls-cert", "./tls/cert.pem", "Path to the TLS certificate PEM file")
+	flag.StringVar(&tlsOpts.keyFile, "tls-key", "./tls/key.pem", "Path to the TLS private key PEM file")
+	tlsReloadInterval := flag.Duration("tls-reload-interval", time.Minute, "How often to check the TLS certificate files for changes (0 to disable)")
+	flag.StringVar(&tlsOpts.minVersion, "tls-min-version", "1.2", "Minimum TLS version (1.2 or 1.3)")
+	flag.StringVar(&tlsOpts.cipherSuites, "tls-cipher-suites", "", "Comma-separated list of TLS 1.2 cipher suites (empty for Go's defaults)")
+	redirectAddr := flag.String("redirect-addr", "", "HTTP network address to redirect to HTTPS, e.g. :80 (empty to disable)")
+
+	// Define command line flags for client certificate authentication. Clients can present a certificate
+	// signed by one of the CAs in the bundle to be logged in as the local user with a matching email address.
+	flag.StringVar(&tlsOpts.clientCAFile, "client-ca-file", "", "PEM file of CA certificates to verify client certificates with")
+	flag.StringVar(&tlsOpts.clientAuth, "client-auth", "none", "Client certificate mode (none, optional or require)")
+
+	// Define command line flags for running behind a reverse proxy. With -plain-http the server doesn't
+	// terminate TLS itself, and relies on the proxy to do it. The X-Forwarded-For, X-Forwarded-Proto and
+	// Forwarded headers are only trusted from the addresses in -trusted-proxies. The base path lets the
+	// application be mounted under a sub-URL, like https://example.com/snippetbox/.
+	plainHTTP := flag.Bool("plain-http", false, "Serve plain HTTP, for running behind a TLS-terminating reverse proxy")
+	basePathFlag := flag.String("base-path", "", "URL path prefix the application is mounted under, e.g. /snippetbox")
+
+	// Define command line flags for the security headers. In the CSP, each {nonce} is replaced with a new
+	// random nonce for every request. The report-only mode lets a stricter policy be tried out without
+	// breaking anything, as violations are only reported to /csp-report. Empty values leave headers out.
+	headers := defaultSecurityHeaders()
+	flag.StringVar(&headers.csp, "csp", headers.csp, "Content-Security-Policy header value")
+	flag.BoolVar(&headers.cspReportOnly, "csp-report-only", false, "Only report Content-Security-Policy violations, rather than blocking them")
+	flag.DurationVar(&headers.hstsMaxAge, "hsts-max-age", headers.hstsMaxAge, "Strict-Transport-Security max age (0 to disable)")
+	flag.BoolVar(&headers.hstsIncludeSubdomains, "hsts-include-subdomains", headers.hstsIncludeSubdomains, "Apply Strict-Transport-Security to subdomains")
+	flag.BoolVar(&headers.hstsPreload, "hsts-preload", false, "Allow the domain to be included in browsers' HSTS preload lists")
+	flag.StringVar(&headers.permissionsPolicy, "permissions-policy", headers.permissionsPolicy, "Permissions-Policy header value")
+	flag.StringVar(&headers.coop, "coop", headers.coop, "Cross-Origin-Opener-Policy header value")
+	flag.StringVar(&headers.coep, "coep", headers.coep, "Cross-Origin-Embedder-Policy header value, e.g. require-corp")
 	flag.Parse()
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelDebug,
-		AddSource: true,
-	}))
+	logger, err := newLogger(os.Stdout, *logFormat, logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-	db, err := openDB(*dsn)
+	var tracerProvider *sdktrace.TracerProvider
+	if *traceExporter != "none" {
+		tracerProvider, err = newTracerProvider(*traceExporter, *traceEndpoint, *traceSampleRatio)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	db, err := openDB(*dsn, dbPool)
 
 	if err != nil {
 		logger.Error(err.Error())
@@ -57,34 +216,147 @@ func main(){
 
 	defer db.Close()
 
-	templateCache, err := newTemplateCache()
+	basePath, err := parseBasePath(*basePathFlag)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	err = headers.validate()
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
 
+	// Load the static assets before the templates, as the templates need their fingerprinted URLs.
+	staticAssets, err := loadStaticAssets(ui.Files, *minifyAssets)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	templateCache, err := newTemplateCache(staticAssets, basePath)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	var oidcProviders []*oidcProvider
+	if *oidcProvidersPath != "" {
+		oidcProviders, err = loadOIDCProviders(context.Background(), *oidcProvidersPath)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	trustedProxies, err := parseTrustedProxies(*trustedProxiesList)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	var rateLimiter ratelimit.Store
+	if *rateLimit {
+		rateLimiter = ratelimit.NewMemoryStore()
+	}
+
+	var users models.UserModelInterface = &models.UserModel{DB: db, QueryTimeout: *queryTimeout}
+
+	switch *authBackend {
+	case "local":
+	case "ldap":
+		ldapTLSConfig, err := newLDAPTLSConfig(*ldapURL, *ldapCAFile)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+
+		users = &models.LDAPUserModel{
+			UserModelInterface: users,
+			Config: models.LDAPConfig{
+				URL:            *ldapURL,
+				StartTLS:       *ldapStartTLS,
+				TLSConfig:      ldapTLSConfig,
+				BindDN:         *ldapBindDN,
+				BindPassword:   *ldapBindPassword,
+				BaseDN:         *ldapBaseDN,
+				UserFilter:     *ldapUserFilter,
+				GroupFilter:    *ldapGroupFilter,
+				NameAttribute:  *ldapNameAttr,
+				EmailAttribute: *ldapEmailAttr,
+				LocalFallback:  *ldapLocalFallback,
+				Timeout:        5 * time.Second,
+			},
+		}
+	default:
+		logger.Error("unknown authentication backend", "auth", *authBackend)
+		os.Exit(1)
+	}
+
 	formDecoder := form.NewDecoder()
 
+	metrics := newMetrics(db)
+
+	var snippets models.SnippetModelInterface = &models.SnippetModel{DB: db, QueryTimeout: *queryTimeout}
+	if *snippetCacheSize > 0 {
+		cached := &models.CachedSnippetModel{
+			SnippetModelInterface: snippets,
+			Cache:                 cache.NewMemoryStore(*snippetCacheSize),
+			TTL:                   *snippetCacheTTL,
+		}
+		metrics.registerSnippetCache(cached.Stats)
+		snippets = cached
+	}
+
+	var commentModerators []commentModerator
+	if *commentMaxLinks >= 0 {
+		commentModerators = append(commentModerators, linkLimitModerator{max: *commentMaxLinks})
+	}
+	if blocklist := newBlocklistModerator(*commentBlocklist); len(blocklist.words) > 0 {
+		commentModerators = append(commentModerators, blocklist)
+	}
+
 	sessionManager := scs.New()
-	sessionManager.Store = mysqlstore.New(db)
-	sessionManager.Lifetime = 12 * time.Hour
+	sessionManager.Store = &instrumentedStore{Store: mysqlstore.New(db), ops: metrics.sessionStoreOps}
+	sessionManager.Lifetime = *sessionLifetime
+	sessionManager.IdleTimeout = *sessionIdleTimeout
+	sessionManager.Cookie.Path = cookiePath(basePath)
 
 
 	app := &application{
 		templateCache: templateCache,
 		logger: logger,
-		snippets: &models.SnippetModel{DB : db},
-		users: &models.UserModel{DB: db},
+		snippets: snippets,
+		users: users,
+		userSessions: &models.UserSessionModel{DB: db, QueryTimeout: *queryTimeout},
+		rememberTokens: &models.RememberTokenModel{DB: db, QueryTimeout: *queryTimeout},
+		comments: &models.CommentModel{DB: db, QueryTimeout: *queryTimeout},
+		commentModerators: commentModerators,
 		formDecoder: formDecoder,
 		sessionManager: sessionManager,
+		rememberLifetime: *rememberLifetime,
+		archiveWriteTimeout: *archiveWriteTimeout,
+		oidcProviders: oidcProviders,
+		trustedProxies: trustedProxies,
+		basePath: basePath,
+		rateLimiter: rateLimiter,
+		metrics: metrics,
+		db: db,
+		staticAssets: staticAssets,
+		securityHeaders: headers,
 	}
 
-	// Initialize a tls.Config struct to hold the non-default TLS settings we want the server to use. In this case
-	// the only thing that we're changing is the curve preferences value, so that only elliptic curves with assembly implementations 
-	// are used
-	tlsConfig := &tls.Config{
-		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+	var certs *certReloader
+	var tlsConfig *tls.Config
+	if !*plainHTTP {
+		tlsConfig, certs, err = newServerTLSConfig(tlsOpts)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
 	}
+
 	// Initialize a new http.Server struct. We set the Addr and Handler fields so
 	// that the server uses the same network address and routes as before.
 	srv := &http.Server{
@@ -100,28 +372,158 @@ func main(){
 		ReadTimeout: 5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
-	logger.Info("Starting a server on %s", "addr",*addr)
+	// Start the admin listener in the background. It serves plain HTTP, so should only listen on a private
+	// interface.
+	if *metricsAddr != "" {
+		adminMux := http.NewServeMux()
+		adminMux.Handle("GET /metrics", metrics.handler())
+
+		adminSrv := &http.Server{
+			Addr: *metricsAddr,
+			Handler: adminMux,
+			ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelError),
+			ReadTimeout: 5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+
+		go func() {
+			logger.Info("Starting admin server", "addr", *metricsAddr)
+			err := adminSrv.ListenAndServe()
+			logger.Error(err.Error())
+		}()
+	}
+
+	// Start the HTTP to HTTPS redirect listener in the background, if enabled. There's nothing to redirect to
+	// if we're serving plain HTTP, as the proxy is responsible for HTTPS.
+	if *redirectAddr != "" && !*plainHTTP {
+		redirectSrv := &http.Server{
+			Addr:         *redirectAddr,
+			Handler:      redirectToHTTPS(*addr),
+			ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+
+		go func() {
+			logger.Info("Starting redirect server", "addr", *redirectAddr)
+			err := redirectSrv.ListenAndServe()
+			logger.Error(err.Error())
+		}()
+	}
+
+	// Watch the certificate files for changes, and reload them when we receive SIGHUP.
+	stopReload := make(chan struct{})
+	defer close(stopReload)
+	if certs != nil {
+		if *tlsReloadInterval > 0 {
+			go certs.watch(*tlsReloadInterval, logger, stopReload)
+		}
+
+		go func() {
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			for range hup {
+				err := certs.reload()
+				if err != nil {
+					logger.Error("reloading TLS certificate", "error", err.Error())
+					continue
+				}
+				logger.Info("Reloaded TLS certificate", "cert", tlsOpts.certFile)
+			}
+		}()
+	}
+
+	// Delete the comments on expired snippets and the records of expired sessions in the background.
+	stopPurge := make(chan struct{})
+	defer close(stopPurge)
+	if *commentPurgeInterval > 0 {
+		go purgeExpiredComments(app.comments, *commentPurgeInterval, logger, stopPurge)
+	}
+	if *sessionPurgeInterval > 0 {
+		go purgeExpiredSessions(app.userSessions, *sessionPurgeInterval, logger, stopPurge)
+	}
+
+	logger.Info("Starting a server on %s", "addr",*addr, "tls", !*plainHTTP, "base_path", basePath)
 	
 	
-	// Use the ListenAndServeTLS method to start the HTTPS server. We pass in the paths to the TLS certificate and corresponding
-	// private key as the two parameters.
+	// Use the ListenAndServeTLS method to start the HTTPS server. We pass empty paths for the TLS certificate and
+	// private key, as the tls.Config already provides them with GetCertificate. In plain HTTP mode we use
+	// ListenAndServe instead.
 	// Note that any error returned by ListenAndServe is always non nil
 	// Each time the server receives a new HTTP request it will pass the request on to 
 	// the servermux and in turn the servemux will check the URL path and dispatch the request
 	// to the matching handler
-	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	logger.Error(err.Error())
-	os.Exit(1)
+	//
+	// When we receive a SIGINT or SIGTERM signal we shut down gracefully. First /readyz starts failing, and we
+	// wait for the shutdown delay so that load balancers notice and stop sending us new requests. Then
+	// Shutdown() stops the listener and waits for the requests in flight to finish. Once Shutdown() is
+	// called, ListenAndServeTLS() returns http.ErrServerClosed straight away, so we wait for the result of
+	// the shutdown on the shutdownErr channel.
+	shutdownErr := make(chan error)
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		logger.Info("Shutting down server", "signal", s.String(), "delay", *shutdownDelay)
+		app.shuttingDown.Store(true)
+		time.Sleep(*shutdownDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		shutdownErr <- srv.Shutdown(ctx)
+	}()
+
+	if *plainHTTP {
+		err = srv.ListenAndServe()
+	} else {
+		err = srv.ListenAndServeTLS("", "")
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = <-shutdownErr
+	}
+
+	// Flush any spans which haven't been exported yet, as the deferred calls won't run after os.Exit().
+	if tracerProvider != nil {
+		tracerProvider.Shutdown(context.Background())
+	}
+
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	logger.Info("Stopped server")
+}
+
+// dbPoolConfig holds the settings for the database connection pool.
+type dbPoolConfig struct {
+	maxOpenConns    int
+	maxIdleConns    int
+	connMaxLifetime time.Duration
+	connMaxIdleTime time.Duration
 }
 
 // openDB() function wraps sql.Open() and returns a sql.DB connection pool for a given DSN
-func openDB(dsn string) (*sql.DB, error) {
+func openDB(dsn string, pool dbPoolConfig) (*sql.DB, error) {
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	err = db.Ping()
+	// Limit the size of the connection pool. Without a limit on open connections, a burst of slow queries
+	// makes the pool open more and more connections until MySQL refuses them, and then everything fails.
+	// With a limit, requests queue for a connection instead (until their context is done).
+	db.SetMaxOpenConns(pool.maxOpenConns)
+	db.SetMaxIdleConns(pool.maxIdleConns)
+	db.SetConnMaxLifetime(pool.connMaxLifetime)
+	db.SetConnMaxIdleTime(pool.connMaxIdleTime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.PingContext(ctx)
 	if err != nil {
 		db.Close()
 		return nil, err
@@ -130,3 +532,56 @@ func openDB(dsn string) (*sql.DB, error) {
 	return db, nil
 }
 
+
+// newLDAPTLSConfig returns the TLS settings for connecting to the LDAP server at ldapURL. If caFile is set,
+// the server certificate is verified against the CA certificates in it instead of the system roots.
+func newLDAPTLSConfig(ldapURL, caFile string) (*tls.Config, error) {
+	u, err := url.Parse(ldapURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// The ServerName must be set explicitly, as it isn't filled in automatically for StartTLS connections.
+	tlsConfig := &tls.Config{
+		ServerName: u.Hostname(),
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		tlsConfig.RootCAs, err = loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// parseTrustedProxies parses a comma-separated list of IP addresses and CIDR ranges.
+func parseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
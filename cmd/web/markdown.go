@@ -0,0 +1,112 @@
+package main
+
+import (
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The inline markdown-lite syntax. The patterns are matched against text which has already been HTML
+// escaped, so they can't match (or produce) any markup other than the tags we add.
+var (
+	markdownCodeRX   = regexp.MustCompile("`([^`\n]+)`")
+	markdownLinkRX   = regexp.MustCompile(`\[([^\]\n]+)\]\((https?://[^\s()]+)\)`)
+	markdownBoldRX   = regexp.MustCompile(`\*\*([^*\n]+)\*\*`)
+	markdownItalicRX = regexp.MustCompile(`\*([^*\n]+)\*`)
+
+	// markdownPlaceholderRX matches the placeholders which markdownInline puts in place of code spans and
+	// links, like "\x003\x00" for the fourth one.
+	markdownPlaceholderRX = regexp.MustCompile("\x00([0-9]+)\x00")
+)
+
+// markdownLite renders the small subset of markdown which comments can use: paragraphs, line breaks,
+// fenced code blocks, `code`, **bold**, *italic* and [links](https://example.com). Everything is HTML
+// escaped first, and only http and https links are allowed, so the result is safe to include in a page
+// without html/template escaping it again. Links get rel="nofollow ugc", so that comment spam doesn't
+// help anyone's search ranking.
+func markdownLite(s string) template.HTML {
+	var b strings.Builder
+
+	// Split the text into code blocks and the paragraphs between them. Code blocks are escaped, but
+	// otherwise left exactly as they are.
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	var paragraph []string
+	flush := func() {
+		text := strings.TrimSpace(strings.Join(paragraph, "\n"))
+		paragraph = nil
+		if text != "" {
+			b.WriteString("<p>")
+			b.WriteString(markdownInline(text))
+			b.WriteString("</p>\n")
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			flush()
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			b.WriteString("<pre><code>")
+			b.WriteString(template.HTMLEscapeString(strings.Join(code, "\n")))
+			b.WriteString("</code></pre>\n")
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		paragraph = append(paragraph, line)
+	}
+	flush()
+
+	return template.HTML(b.String())
+}
+
+// markdownInline escapes a paragraph of text and renders its inline markdown. Code spans and links are
+// replaced by numbered placeholders while the rest is rendered, so that the text inside code spans and the
+// link URLs aren't treated as markdown, and then each placeholder is swapped back for its own HTML. Link
+// text can contain code spans, so their placeholders are restored inside the links too. HTMLEscapeString
+// replaces any NUL characters in the text, so the placeholders can't come from the comment itself.
+func markdownInline(text string) string {
+	text = template.HTMLEscapeString(text)
+
+	var stashed []string
+	stash := func(html string) string {
+		stashed = append(stashed, html)
+		return "\x00" + strconv.Itoa(len(stashed)-1) + "\x00"
+	}
+
+	text = markdownCodeRX.ReplaceAllStringFunc(text, func(m string) string {
+		return stash("<code>" + markdownCodeRX.FindStringSubmatch(m)[1] + "</code>")
+	})
+	text = markdownLinkRX.ReplaceAllStringFunc(text, func(m string) string {
+		match := markdownLinkRX.FindStringSubmatch(m)
+		return stash(`<a href="` + match[2] + `" rel="nofollow ugc noopener">` + markdownEmphasis(match[1]) + `</a>`)
+	})
+	text = markdownEmphasis(text)
+	text = strings.ReplaceAll(text, "\n", "<br>\n")
+
+	// Each stashed fragment can only contain placeholders for fragments stashed before it, so this always
+	// finishes.
+	var restore func(string) string
+	restore = func(s string) string {
+		return markdownPlaceholderRX.ReplaceAllStringFunc(s, func(m string) string {
+			i, _ := strconv.Atoi(m[1 : len(m)-1])
+			return restore(stashed[i])
+		})
+	}
+
+	return restore(text)
+}
+
+// markdownEmphasis renders **bold** and *italic* text.
+func markdownEmphasis(text string) string {
+	text = markdownBoldRX.ReplaceAllString(text, "<strong>$1</strong>")
+	return markdownItalicRX.ReplaceAllString(text, "<em>$1</em>")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+func TestMarkdownLite(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "Paragraphs",
+			input: "One\ntwo\n\nThree",
+			want:  "<p>One<br>\ntwo</p>\n<p>Three</p>\n",
+		},
+		{
+			name:  "Emphasis",
+			input: "**bold** and *italic*",
+			want:  "<p><strong>bold</strong> and <em>italic</em></p>\n",
+		},
+		{
+			name:  "HTML",
+			input: `<script>alert("hi")</script>`,
+			want:  "<p>&lt;script&gt;alert(&#34;hi&#34;)&lt;/script&gt;</p>\n",
+		},
+		{
+			name:  "Code span",
+			input: "Use `**x** <b>` here",
+			want:  "<p>Use <code>**x** &lt;b&gt;</code> here</p>\n",
+		},
+		{
+			name:  "Code block",
+			input: "Look:\n```\nif a < b {\n\n    *x*\n}\n```\ndone",
+			want:  "<p>Look:</p>\n<pre><code>if a &lt; b {\n\n    *x*\n}</code></pre>\n<p>done</p>\n",
+		},
+		{
+			name:  "Link",
+			input: "[a *pond*](https://example.com/a_b)",
+			want:  `<p><a href="https://example.com/a_b" rel="nofollow ugc noopener">a <em>pond</em></a></p>` + "\n",
+		},
+		{
+			name:  "JavaScript link",
+			input: "[click](javascript:alert(1))",
+			want:  "<p>[click](javascript:alert(1))</p>\n",
+		},
+		{
+			name:  "Quotes in link",
+			input: `[x](https://example.com/"onmouseover="alert)`,
+			want:  `<p><a href="https://example.com/&#34;onmouseover=&#34;alert" rel="nofollow ugc noopener">x</a></p>` + "\n",
+		},
+		{
+			name:  "Link before code span",
+			input: "see [docs](https://a.example) and `x`",
+			want:  `<p>see <a href="https://a.example" rel="nofollow ugc noopener">docs</a> and <code>x</code></p>` + "\n",
+		},
+		{
+			name:  "Code span in link",
+			input: "[`code`](https://a.example)",
+			want:  `<p><a href="https://a.example" rel="nofollow ugc noopener"><code>code</code></a></p>` + "\n",
+		},
+		{
+			name:  "Many placeholders",
+			input: "`a` `b` `c` `d` `e` `f` `g` `h` `i` `j` `k`",
+			want:  "<p><code>a</code> <code>b</code> <code>c</code> <code>d</code> <code>e</code> <code>f</code> <code>g</code> <code>h</code> <code>i</code> <code>j</code> <code>k</code></p>\n",
+		},
+		{
+			name:  "Empty",
+			input: "  \n\n ",
+			want:  "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, string(markdownLite(tt.input)), tt.want)
+		})
+	}
+}
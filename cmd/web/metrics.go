@@ -0,0 +1,168 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vishal-rfx/snippetbox/internal/models"
+)
+
+// metrics holds the Prometheus collectors for the application. Each application has its own registry
+// (rather than using the global default one) so that tests can create as many applications as they like.
+type metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	renderDuration  *prometheus.HistogramVec
+	sessionStoreOps *prometheus.CounterVec
+	snippetsCreated prometheus.Counter
+	loginsSucceeded *prometheus.CounterVec
+	loginsFailed    *prometheus.CounterVec
+	cspViolations   *prometheus.CounterVec
+}
+
+// newMetrics creates and registers all of the application's metrics. If db is not nil, the connection pool
+// statistics from db.Stats() are exported too.
+func newMetrics(db *sql.DB) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_http_requests_total",
+			Help: "Total number of HTTP requests, by route pattern, method and status code.",
+		}, []string{"route", "method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "snippetbox_http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests, by route pattern and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		renderDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "snippetbox_template_render_duration_seconds",
+			Help:    "Time taken to execute page templates, by page.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+		}, []string{"page"}),
+		sessionStoreOps: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_session_store_operations_total",
+			Help: "Total number of session store operations, by operation and result.",
+		}, []string{"operation", "result"}),
+		snippetsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "snippetbox_snippets_created_total",
+			Help: "Total number of snippets created.",
+		}),
+		loginsSucceeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_logins_succeeded_total",
+			Help: "Total number of successful logins, by method.",
+		}, []string{"method"}),
+		loginsFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_logins_failed_total",
+			Help: "Total number of failed logins, by method and reason.",
+		}, []string{"method", "reason"}),
+		cspViolations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_csp_violations_total",
+			Help: "Total number of Content-Security-Policy violations reported by browsers, by directive.",
+		}, []string{"directive"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.renderDuration,
+		m.sessionStoreOps,
+		m.snippetsCreated,
+		m.loginsSucceeded,
+		m.loginsFailed,
+		m.cspViolations,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	if db != nil {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(db, "snippetbox"))
+	}
+
+	return m
+}
+
+// registerSnippetCache exports the hit, miss and error counts of the snippet cache.
+func (m *metrics) registerSnippetCache(stats func() models.CacheStats) {
+	m.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "snippetbox_snippet_cache_hits_total",
+			Help: "Total number of snippet reads served from the cache.",
+		}, func() float64 { return float64(stats().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "snippetbox_snippet_cache_misses_total",
+			Help: "Total number of snippet reads which went to the database.",
+		}, func() float64 { return float64(stats().Misses) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "snippetbox_snippet_cache_errors_total",
+			Help: "Total number of failed snippet cache operations.",
+		}, func() float64 { return float64(stats().Errors) }),
+	)
+}
+
+// handler returns a HTTP handler which serves the metrics in the Prometheus text format.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// instrumentRequest is a middleware which records the count and latency of every request. It should be the
+// first middleware in the chain, so that it sees the final status code of every response (including panics
+// recovered by recoverPanic). Requests are labelled with the pattern of the route which handled them rather
+// than the path, so that the number of label values stays small.
+func (app *application) instrumentRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := newResponseRecorder(w)
+
+		next.ServeHTTP(rec, r)
+
+		// The servemux sets r.Pattern on the request it is given, which is the same *http.Request we have
+		// here as long as none of the middleware in between made a copy of it.
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+
+		app.metrics.requests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		app.metrics.requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// instrumentedStore wraps a scs session store, counting the operations made on it.
+type instrumentedStore struct {
+	scs.Store
+	ops *prometheus.CounterVec
+}
+
+func (s *instrumentedStore) Find(token string) ([]byte, bool, error) {
+	b, found, err := s.Store.Find(token)
+	s.count("find", err)
+	return b, found, err
+}
+
+func (s *instrumentedStore) Commit(token string, b []byte, expiry time.Time) error {
+	err := s.Store.Commit(token, b, expiry)
+	s.count("commit", err)
+	return err
+}
+
+func (s *instrumentedStore) Delete(token string) error {
+	err := s.Store.Delete(token)
+	s.count("delete", err)
+	return err
+}
+
+func (s *instrumentedStore) count(operation string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+
+	s.ops.WithLabelValues(operation, result).Inc()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.get(t, "/ping")
+	ts.get(t, "/snippet/view/1")
+	ts.get(t, "/snippet/view/2")
+	ts.get(t, "/no/such/page")
+
+	rr := httptest.NewRecorder()
+	r, err := http.NewRequest(http.MethodGet, "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.metrics.handler().ServeHTTP(rr, r)
+
+	rs := rr.Result()
+	defer rs.Body.Close()
+	body, err := io.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, rs.StatusCode, http.StatusOK)
+	assert.StringContains(t, string(body), `snippetbox_http_requests_total{code="200",method="GET",route="GET /ping"} 1`)
+	assert.StringContains(t, string(body), `snippetbox_http_requests_total{code="200",method="GET",route="GET /snippet/view/{id}"} 1`)
+	assert.StringContains(t, string(body), `snippetbox_http_requests_total{code="404",method="GET",route="GET /snippet/view/{id}"} 1`)
+	assert.StringContains(t, string(body), `snippetbox_http_requests_total{code="404",method="GET",route="unmatched"} 1`)
+	assert.StringContains(t, string(body), `snippetbox_template_render_duration_seconds_count{page="view.tmpl.html"} 1`)
+}
@@ -2,37 +2,41 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/justinas/nosurf"
+	"github.com/vishal-rfx/snippetbox/internal/models"
+	"github.com/vishal-rfx/snippetbox/internal/ratelimit"
 )
 
-func commonHeaders(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com")
-
-		w.Header().Set("Referrer-Policy", "origin-when-cross-origin")
-		w.Header().Set("X-Content-Type-Options", "nosniff")
-		w.Header().Set("X-Frame-Options", "deny")
-		w.Header().Set("X-XSS-Protection", "0")
-		w.Header().Set("Server", "Go")
-		next.ServeHTTP(w, r)
-	})
-}
-
-
+// logRequest writes an access log entry for each request once it has been handled, so that the entry can
+// include the response status, size and how long it took. The ID of the authenticated user (if any) is
+// filled in by the authenticate middleware via the requestLog in the context.
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func (w http.ResponseWriter, r *http.Request)  {
-		var (
-			ip = r.RemoteAddr
-			proto = r.Proto
-			method = r.Method
-			uri = r.URL.RequestURI()
-		)
+		start := time.Now()
+		rec := newResponseRecorder(w)
+		l := &requestLog{}
 
-		app.logger.Info("Received request", "ip", ip, "proto", proto, "method", method, "uri", uri)
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), requestLogContextKey, l)
+		serveWithContext(rec, r, ctx, next)
+
+		app.logger.InfoContext(r.Context(), "Handled request",
+			"ip", app.clientIP(r),
+			"proto", r.Proto,
+			"method", r.Method,
+			"uri", r.URL.RequestURI(),
+			"route", r.Pattern,
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+			"user_id", l.userID,
+		)
 	})
 }
 
@@ -42,6 +46,11 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		defer func ()  {
 			// Use the builtin recover function to check if there has been a panic or not. If there was..
 			if err := recover(); err != nil {
+				// http.ErrAbortHandler is used to abort a response which has already been started, so
+				// let the server deal with it rather than trying to send an error page.
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
 				w.Header().Set("Connection", "close")
 				app.serverError(w, r, fmt.Errorf("%s", err))
 			}
@@ -55,40 +64,120 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 		// If the user is not authenticated, redirect them to the login page and return from middleware chain so
 		// that no subsequent handlers in the chan are executed
 		if !app.isAuthenticated(r){
-			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			http.Redirect(w, r, app.url("/user/login"), http.StatusSeeOther)
 			return
 		}
 
 		// Otherwise set the Cache-Control: no-store header so that pages require 
 		// authentication are not stored in the users browser cache ( or other intermediary cache)
 		w.Header().Add("Cache-Control", "no-store")
+
+		next.ServeHTTP(w, r)
+
+	})
+}
+
+// requirePasswordCurrent redirects users who an admin has forced to reset their password to the change
+// password page, so that they can't do anything else until they've changed it. It must come after
+// requireAuthentication in the chain, and is left out of the routes which those users still need. Users who
+// logged in with a client certificate didn't use their password, so they aren't redirected.
+func (app *application) requirePasswordCurrent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.authenticatedUser(r).PasswordResetRequired && !app.isClientCertLogin(r) {
+			http.Redirect(w, r, app.url("/account/password"), http.StatusSeeOther)
+			return
+		}
+
 		next.ServeHTTP(w, r)
+	})
+}
 
+// requireSession sends a 403 Forbidden response to users who logged in with a client certificate. They don't
+// have a session or use their password, so the pages for managing those don't apply to them. It must come
+// after requireAuthentication in the chain.
+func (app *application) requireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.isClientCertLogin(r) {
+			http.Error(w, "This page isn't available when you log in with a client certificate", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
 	})
 }
 
+// requireRole returns a middleware which only allows users with at least the given role through, and sends
+// a 403 Forbidden response to everyone else. It must come after requireAuthentication in the chain.
+func (app *application) requireRole(role models.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.authenticatedUser(r).Role.Allows(role) {
+				app.clientError(w, r, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func (w http.ResponseWriter, r *http.Request)  {
 		// Retrieve the authenticatedUserID value from the session using the GetInt() method. This will return
 		// the zero value for an int (0) if no "authenticatedUserID" value is in the session in which case we 
 		// call the next handler in the chain as normal return.
 		id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+		if id == 0 {
+			// If there's no authenticated user in the session, check for a remember me cookie which can
+			// transparently log the user back in on a new session.
+			var err error
+			id, err = app.restoreRememberedSession(w, r)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+		}
+		// Clients which present a verified certificate are logged in without a session, for as long as they
+		// keep presenting it.
+		fromClientCert := false
+		if id == 0 {
+			var err error
+			id, err = app.clientCertUser(r)
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			fromClientCert = id != 0
+		}
 		if id == 0 {
 			next.ServeHTTP(w, r)
 			return
 		}
-		// Otherwise, we check to see if a user with that ID exists in our database
-		exists, err := app.users.Exists(id)
-		if err != nil {
+		// Otherwise, we fetch the user with that ID from our database
+		user, err := app.users.Get(r.Context(), id)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
 			app.serverError(w, r, err)
 			return
 		}
-		// If a matching user is found, we know that the request is coming from an authenticated user who
-		// exists in our database. We create a new copy of the request (with an isAuthenticatedContextKey)
-		// value of true in the request context and assign it to r.
-		if exists {
+		// If a matching user is found and their account hasn't been disabled, we know that the request is
+		// coming from an authenticated user who exists in our database. We create a new copy of the request
+		// (with an isAuthenticatedContextKey value of true and the user's details) in the request context and
+		// assign it to r.
+		if err == nil && !user.Disabled {
 			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+			ctx = context.WithValue(ctx, authenticatedUserContextKey, user)
+			ctx = context.WithValue(ctx, clientCertLoginContextKey, fromClientCert)
 			r = r.WithContext(ctx)
+			setRequestLogUser(r, user.ID)
+
+			// Keep the last seen time for this session up to date on the active sessions page.
+			if !fromClientCert {
+				err = app.userSessions.Touch(r.Context(), app.sessionManager.Token(r.Context()), app.clientIP(r))
+				if err != nil {
+					app.serverError(w, r, err)
+					return
+				}
+			}
 		}
 
 		next.ServeHTTP(w, r)
@@ -97,14 +186,97 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 }
 
 
-// NoSurf middleware function which uses a customized CSRF cookie with the Secure, Path and HttpOnly attributes set.
-func noSurf(next http.Handler) http.Handler {
-	csrfHandler := nosurf.New(next)
-	csrfHandler.SetBaseCookie(http.Cookie{
-		HttpOnly: true, // Normal cookies are accessible to javascripts, but by setting HttpOnly true we prevent the client scripts to access our cookie
-		Path: "/",
-		Secure: true, // Enforce HTTPS
+// noSurf returns the CSRF protection middleware, which uses a customized CSRF cookie with the Secure, Path
+// and HttpOnly attributes set. The Secure attribute is only set for requests made over HTTPS, as browsers
+// won't send the cookie back otherwise, so we keep one handler for each scheme.
+func (app *application) noSurf(next http.Handler) http.Handler {
+	newHandler := func(secure bool) http.Handler {
+		csrfHandler := nosurf.New(next)
+		csrfHandler.SetBaseCookie(http.Cookie{
+			HttpOnly: true, // Normal cookies are accessible to javascripts, but by setting HttpOnly true we prevent the client scripts to access our cookie
+			Path:     cookiePath(app.basePath),
+			Secure:   secure, // Enforce HTTPS
+		})
+		return csrfHandler
+	}
+
+	secureHandler := newHandler(true)
+	plainHandler := newHandler(false)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.isHTTPS(r) {
+			secureHandler.ServeHTTP(w, r)
+			return
+		}
+		plainHandler.ServeHTTP(w, r)
 	})
+}
+
+// rateLimit returns a middleware which limits how often each client can make requests, according to the
+// given policy. Authenticated users are limited by their user ID (so it must come after authenticate in the
+// chain to have any effect), and everyone else by their IP address. The name separates the buckets of
+// different route groups, so that each group has its own limit.
+func (app *application) rateLimit(name string, policy ratelimit.Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if app.rateLimiter == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := name + ":ip:" + app.clientIP(r)
+			if user := app.authenticatedUser(r); user.ID != 0 {
+				key = name + ":user:" + strconv.Itoa(user.ID)
+			}
 
-	return csrfHandler
-}
\ No newline at end of file
+			ok, retryAfter, err := app.rateLimiter.Allow(key, policy)
+			if err != nil {
+				// If a shared store is unavailable we'd rather let requests through than take the whole
+				// site down, so just log the error.
+				app.logger.ErrorContext(r.Context(), err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				app.clientError(w, r, http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// responseRecorder wraps a http.ResponseWriter, recording the status code and size of the response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	if !rr.wroteHeader {
+		rr.status = code
+		rr.wroteHeader = true
+	}
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.wroteHeader = true
+	n, err := rr.ResponseWriter.Write(b)
+	rr.bytes += n
+	return n, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter, so that http.ResponseController can reach it.
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
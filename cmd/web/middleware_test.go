@@ -5,6 +5,8 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/vishal-rfx/snippetbox/internal/assert"
@@ -12,6 +14,7 @@ import (
 
 
 func TestCommonHeaders(t *testing.T) {
+	app := newTestApplication(t)
 	rr := httptest.NewRecorder()
 
 	r, err := http.NewRequest(http.MethodGet, "/", nil)
@@ -23,22 +26,36 @@ func TestCommonHeaders(t *testing.T) {
 	// Create a mock HTTP handler that we can pass to our commonHeaders middleware, which writes a 200 status code and an
 	// "OK" response body.
 
+	var nonce string
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = cspNonce(r)
 		w.Write([]byte("OK"))
 	})
 
 	// Pass the mock HTTP handler to our commonHeaders middleware. Because commonHeaders 
 	// *returns* a http.Handler we can call its ServeHTTP() method, passing in the 
 	// http.ResponseRecorder and dummy http.Request to execute it.
-	commonHeaders(next).ServeHTTP(rr, r)
+	app.commonHeaders(next).ServeHTTP(rr, r)
 	// Call the Result() method on http.ResponseRecorder to get the results of the test
 	rs := rr.Result()
 
 	// Check that the middleware has correctly set the Content-Security-Policy header 
-	// on the response
-	expectedValue := "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com"
+	// on the response, with the nonce that it passed on to the handler
+	if nonce == "" {
+		t.Fatal("no CSP nonce in the request context")
+	}
+	expectedValue := strings.ReplaceAll(defaultCSP, "{nonce}", nonce) + "; report-uri /csp-report"
 	assert.Equal(t, rs.Header.Get("Content-Security-Policy"), expectedValue)
 
+	expectedValue = defaultPermissionsPolicy
+	assert.Equal(t, rs.Header.Get("Permissions-Policy"), expectedValue)
+
+	expectedValue = "same-origin"
+	assert.Equal(t, rs.Header.Get("Cross-Origin-Opener-Policy"), expectedValue)
+
+	// HSTS is only sent over HTTPS.
+	assert.Equal(t, rs.Header.Get("Strict-Transport-Security"), "")
+
 	expectedValue = "origin-when-cross-origin"
 	assert.Equal(t, rs.Header.Get("Referrer-Policy"), expectedValue)
 
@@ -63,4 +80,149 @@ func TestCommonHeaders(t *testing.T) {
 
 	assert.Equal(t, string(body), "OK")
 
-}
\ No newline at end of file
+}
+
+func TestRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("email", "alice@example.com")
+	form.Add("password", "wrong-password")
+	form.Add("csrf_token", csrfToken)
+
+	for i := 0; i < authRateLimit.Limit; i++ {
+		code, _, _ := ts.postForm(t, "/user/login", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	}
+
+	code, header, _ := ts.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, header.Get("Retry-After"), "6")
+
+	// Other route groups have their own limits.
+	code, _, _ = ts.get(t, "/user/login")
+	assert.Equal(t, code, http.StatusOK)
+}
+
+func TestDefaultRateLimit(t *testing.T) {
+	policy := defaultRateLimit
+	defaultRateLimit.Limit = 2
+	t.Cleanup(func() { defaultRateLimit = policy })
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Static files and health checks aren't counted against the limit.
+	for i := 0; i < 5; i++ {
+		code, _, _ := ts.get(t, "/static/css/main.css")
+		assert.Equal(t, code, http.StatusOK)
+
+		code, _, _ = ts.get(t, "/healthz")
+		assert.Equal(t, code, http.StatusOK)
+	}
+
+	for i := 0; i < defaultRateLimit.Limit; i++ {
+		code, _, _ := ts.get(t, "/")
+		assert.Equal(t, code, http.StatusOK)
+	}
+
+	code, _, _ := ts.get(t, "/")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+}
+
+func TestClientIP(t *testing.T) {
+	app := newTestApplication(t)
+
+	trustedProxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.trustedProxies = trustedProxies
+
+	tests := []struct {
+		name          string
+		remoteAddr    string
+		xForwardedFor string
+		forwarded     string
+		want          string
+	}{
+		{
+			name:       "Direct connection",
+			remoteAddr: "203.0.113.5:1234",
+			want:       "203.0.113.5",
+		},
+		{
+			name:          "Untrusted proxy",
+			remoteAddr:    "203.0.113.5:1234",
+			xForwardedFor: "198.51.100.7",
+			want:          "203.0.113.5",
+		},
+		{
+			name:          "Trusted proxy",
+			remoteAddr:    "10.1.2.3:1234",
+			xForwardedFor: "198.51.100.7",
+			want:          "198.51.100.7",
+		},
+		{
+			name:          "Chain of trusted proxies",
+			remoteAddr:    "10.1.2.3:1234",
+			xForwardedFor: "198.51.100.7, 192.0.2.1, 10.4.5.6",
+			want:          "198.51.100.7",
+		},
+		{
+			name:          "Forged header",
+			remoteAddr:    "10.1.2.3:1234",
+			xForwardedFor: "10.9.9.9, 198.51.100.7",
+			want:          "198.51.100.7",
+		},
+		{
+			name:          "Invalid header",
+			remoteAddr:    "10.1.2.3:1234",
+			xForwardedFor: "not-an-ip",
+			want:          "10.1.2.3",
+		},
+		{
+			name:       "Forwarded header",
+			remoteAddr: "10.1.2.3:1234",
+			forwarded:  `for=198.51.100.7;proto=https, for="[2001:db8::1]:4711"`,
+			want:       "2001:db8::1",
+		},
+		{
+			name:          "Forwarded header takes precedence",
+			remoteAddr:    "10.1.2.3:1234",
+			xForwardedFor: "198.51.100.8",
+			forwarded:     "for=198.51.100.7, for=192.0.2.1",
+			want:          "198.51.100.7",
+		},
+		{
+			name:       "Obfuscated Forwarded address",
+			remoteAddr: "10.1.2.3:1234",
+			forwarded:  "for=_hidden, for=10.4.5.6",
+			want:       "10.4.5.6",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.RemoteAddr = tt.remoteAddr
+			if tt.xForwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.xForwardedFor)
+			}
+			if tt.forwarded != "" {
+				r.Header.Set("Forwarded", tt.forwarded)
+			}
+
+			assert.Equal(t, app.clientIP(r), tt.want)
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/vishal-rfx/snippetbox/internal/models"
+)
+
+// moderationAction is what a commentModerator wants done with a comment.
+type moderationAction int
+
+const (
+	// moderationAllow publishes the comment, as far as this moderator is concerned.
+	moderationAllow moderationAction = iota
+	// moderationHold keeps the comment pending until a moderator approves it.
+	moderationHold
+	// moderationReject refuses the comment, and the reason is shown to the author.
+	moderationReject
+)
+
+// moderationDecision is the result of checking a comment.
+type moderationDecision struct {
+	action moderationAction
+	reason string
+}
+
+// commentModerator is a hook for checking comments before they're saved, when they're posted and when
+// they're edited. Implementations can hold comments for a moderator to look at, or reject them outright.
+// This is where a spam filter or an external moderation service can be plugged in.
+type commentModerator interface {
+	moderateComment(ctx context.Context, c models.Comment) (moderationDecision, error)
+}
+
+// moderateComment runs a comment past each of the application's comment moderators, and returns the status
+// it should be saved with. If any moderator rejects the comment, it's rejected with that moderator's
+// reason. Comments by moderators are always published.
+func (app *application) moderateComment(ctx context.Context, c models.Comment, author models.User) (models.CommentStatus, string, error) {
+	if author.Role.Allows(models.RoleModerator) {
+		return models.CommentPublished, "", nil
+	}
+
+	status := models.CommentPublished
+	for _, m := range app.commentModerators {
+		decision, err := m.moderateComment(ctx, c)
+		if err != nil {
+			return "", "", err
+		}
+
+		switch decision.action {
+		case moderationReject:
+			app.logger.InfoContext(ctx, "Comment rejected", "snippet", c.SnippetID, "user", c.UserID, "reason", decision.reason)
+			return "", decision.reason, nil
+		case moderationHold:
+			app.logger.InfoContext(ctx, "Comment held for moderation", "snippet", c.SnippetID, "user", c.UserID, "reason", decision.reason)
+			status = models.CommentPending
+		}
+	}
+
+	return status, "", nil
+}
+
+// commentURLRX matches the web addresses in a comment, whether they're in a link or not.
+var commentURLRX = regexp.MustCompile(`https?://`)
+
+// linkLimitModerator holds comments with more than max links, which are a common sign of spam.
+type linkLimitModerator struct {
+	max int
+}
+
+func (m linkLimitModerator) moderateComment(ctx context.Context, c models.Comment) (moderationDecision, error) {
+	if links := len(commentURLRX.FindAllString(c.Body, -1)); links > m.max {
+		return moderationDecision{action: moderationHold, reason: fmt.Sprintf("%d links", links)}, nil
+	}
+	return moderationDecision{action: moderationAllow}, nil
+}
+
+// blocklistModerator rejects comments which contain any of the words in its list, ignoring case.
+type blocklistModerator struct {
+	words []string
+}
+
+// newBlocklistModerator returns a blocklistModerator for a comma-separated list of words.
+func newBlocklistModerator(list string) blocklistModerator {
+	var m blocklistModerator
+	for _, word := range strings.Split(list, ",") {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			m.words = append(m.words, word)
+		}
+	}
+	return m
+}
+
+func (m blocklistModerator) moderateComment(ctx context.Context, c models.Comment) (moderationDecision, error) {
+	body := strings.ToLower(c.Body)
+	for _, word := range m.words {
+		if strings.Contains(body, word) {
+			return moderationDecision{action: moderationReject, reason: "This comment contains words which aren't allowed"}, nil
+		}
+	}
+	return moderationDecision{action: moderationAllow}, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/vishal-rfx/snippetbox/internal/models"
+)
+
+// parseClientAuth parses the client certificate mode given on the command line. With "optional", clients
+// can present a certificate to log in, and everyone else can still use the site (and the login form) as
+// normal. With "require", the TLS handshake fails for clients without a valid certificate.
+func parseClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "none":
+		return tls.NoClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("unsupported client certificate mode %q (use none, optional or require)", mode)
+	}
+}
+
+// loadCertPool reads a PEM bundle of CA certificates.
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	return pool, nil
+}
+
+// clientCertIdentities returns the names in a client certificate which can identify a local user, in order
+// of preference: the email addresses in its subject alternative names, then the subject common name.
+func clientCertIdentities(cert *x509.Certificate) []string {
+	identities := append([]string{}, cert.EmailAddresses...)
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+
+	return identities
+}
+
+// clientCertUser returns the ID of the local user that the request's client certificate belongs to, or 0
+// if there is no verified client certificate or it doesn't match any user. The certificate is matched to
+// the user whose email address is one of its identities. We only look at verified chains, so certificates
+// which weren't signed by the configured CA are ignored, and machine clients must be given a local account
+// before they can log in.
+func (app *application) clientCertUser(r *http.Request) (int, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return 0, nil
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	for _, identity := range clientCertIdentities(cert) {
+		user, err := app.users.GetByEmail(r.Context(), identity)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				continue
+			}
+			return 0, err
+		}
+
+		return user.ID, nil
+	}
+
+	app.logger.WarnContext(r.Context(), "no user for client certificate", "subject", cert.Subject.String(), "ip", app.clientIP(r))
+	return 0, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+// newTestCA creates a self-signed CA certificate and its key.
+func newTestCA(t *testing.T) (*x509.Certificate, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+// newTestClientCert issues a client certificate signed by the CA.
+func newTestClientCert(t *testing.T, ca *x509.Certificate, caKey crypto.Signer, commonName string, emails ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(2),
+		Subject:        pkix.Name{CommonName: commonName},
+		EmailAddresses: emails,
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClientCertAuthentication(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	otherCA, otherCAKey := newTestCA(t)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	tests := []struct {
+		name         string
+		certs        []tls.Certificate
+		wantCode     int
+		wantTLSError bool
+	}{
+		{
+			name:     "No certificate",
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:     "Email SAN",
+			certs:    []tls.Certificate{newTestClientCert(t, ca, caKey, "deploy-bot", "alice@example.com")},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Common name",
+			certs:    []tls.Certificate{newTestClientCert(t, ca, caKey, "admin@example.com")},
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Unknown user",
+			certs:    []tls.Certificate{newTestClientCert(t, ca, caKey, "nobody", "nobody@example.com")},
+			wantCode: http.StatusSeeOther,
+		},
+		{
+			name:         "Untrusted CA",
+			certs:        []tls.Certificate{newTestClientCert(t, otherCA, otherCAKey, "alice", "alice@example.com")},
+			wantTLSError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+
+			ts := httptest.NewUnstartedServer(app.routes())
+			ts.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: pool}
+			ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+			ts.StartTLS()
+			defer ts.Close()
+
+			client := ts.Client()
+			client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			}
+
+			client.Transport.(*http.Transport).TLSClientConfig.Certificates = tt.certs
+
+			// Certificates which are given are always verified, so an untrusted one fails the handshake.
+			rs, err := client.Get(ts.URL + "/snippet/create/")
+			if tt.wantTLSError {
+				if err == nil {
+					rs.Body.Close()
+					t.Fatal("expected the TLS handshake to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			rs.Body.Close()
+
+			assert.Equal(t, rs.StatusCode, tt.wantCode)
+		})
+	}
+}
+
+func TestClientCertAccountPages(t *testing.T) {
+	ca, caKey := newTestCA(t)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	app := newTestApplication(t)
+
+	ts := httptest.NewUnstartedServer(app.routes())
+	ts.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: pool}
+	ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+	ts.StartTLS()
+	defer ts.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := ts.Client()
+	client.Jar = jar
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{
+		newTestClientCert(t, ca, caKey, "deploy-bot", "alice@example.com"),
+	}
+
+	rs, err := client.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(rs.Body)
+	rs.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// There's no link to the sessions page, as the user doesn't have a session.
+	assert.Equal(t, rs.StatusCode, http.StatusOK)
+	assert.Equal(t, strings.Contains(string(body), "/account/sessions"), false)
+
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, string(body)))
+	form.Add("currentPassword", "password")
+	form.Add("newPassword", "newPassword")
+	form.Add("newPasswordConfirmation", "newPassword")
+
+	tests := []struct {
+		method  string
+		urlPath string
+	}{
+		{method: http.MethodGet, urlPath: "/account/password"},
+		{method: http.MethodPost, urlPath: "/account/password"},
+		{method: http.MethodGet, urlPath: "/account/sessions"},
+		{method: http.MethodPost, urlPath: "/account/sessions/revoke/1"},
+		{method: http.MethodPost, urlPath: "/account/sessions/revoke-others"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.urlPath, func(t *testing.T) {
+			var rs *http.Response
+			var err error
+			if tt.method == http.MethodPost {
+				rs, err = client.PostForm(ts.URL+tt.urlPath, form)
+			} else {
+				rs, err = client.Get(ts.URL + tt.urlPath)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := io.ReadAll(rs.Body)
+			rs.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, rs.StatusCode, http.StatusForbidden)
+			assert.StringContains(t, string(body), "client certificate")
+		})
+	}
+}
+
+func TestParseClientAuth(t *testing.T) {
+	mode, err := parseClientAuth("require")
+	assert.NilError(t, err)
+	assert.Equal(t, mode, tls.RequireAndVerifyClientCert)
+
+	_, err = parseClientAuth("sometimes")
+	if err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
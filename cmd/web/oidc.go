@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/vishal-rfx/snippetbox/internal/models"
+	"github.com/vishal-rfx/snippetbox/internal/random"
+	"golang.org/x/oauth2"
+)
+
+// oidcProviderConfig holds the settings for a single OpenID Connect provider, as read from the JSON file
+// given by the -oidc-providers command line flag. The file should contain an array of these objects.
+type oidcProviderConfig struct {
+	Name         string   `json:"name"`
+	DisplayName  string   `json:"display_name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url"`
+	Scopes       []string `json:"scopes"`
+}
+
+// oidcProvider is an OpenID Connect provider that users can log in with. The Name is used in the login URLs
+// and the DisplayName is shown on the login button.
+type oidcProvider struct {
+	Name        string
+	DisplayName string
+	oauth2      oauth2.Config
+	verifier    *oidc.IDTokenVerifier
+}
+
+// loadOIDCProviders reads the provider configuration file at path and sets up each provider, using OpenID
+// Connect discovery to find its endpoints and signing keys.
+func loadOIDCProviders(ctx context.Context, path string) ([]*oidcProvider, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []oidcProviderConfig
+	err = json.Unmarshal(b, &configs)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	var providers []*oidcProvider
+	for _, cfg := range configs {
+		p, err := newOIDCProvider(ctx, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("oidc provider %q: %w", cfg.Name, err)
+		}
+
+		providers = append(providers, p)
+	}
+
+	return providers, nil
+}
+
+func newOIDCProvider(ctx context.Context, cfg oidcProviderConfig) (*oidcProvider, error) {
+	if cfg.Name == "" || cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("name, issuer, client_id and redirect_url are required")
+	}
+
+	provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	displayName := cfg.DisplayName
+	if displayName == "" {
+		displayName = cfg.Name
+	}
+
+	scopes := []string{oidc.ScopeOpenID, "email", "profile"}
+	if len(cfg.Scopes) > 0 {
+		scopes = append([]string{oidc.ScopeOpenID}, cfg.Scopes...)
+	}
+
+	return &oidcProvider{
+		Name:        cfg.Name,
+		DisplayName: displayName,
+		oauth2: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}, nil
+}
+
+// oidcProvider returns the configured provider with the given name, or nil if there isn't one.
+func (app *application) oidcProvider(name string) *oidcProvider {
+	for _, p := range app.oidcProviders {
+		if p.Name == name {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// userLoginOIDC starts the authorization code flow to log in through a provider.
+func (app *application) userLoginOIDC(w http.ResponseWriter, r *http.Request) {
+	provider := app.oidcProvider(r.PathValue("provider"))
+	if provider == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	app.sessionManager.Remove(r.Context(), "oidcLinkUserID")
+	app.redirectToOIDCProvider(w, r, provider)
+}
+
+// accountLinkOIDCPost starts the authorization code flow to link an identity at a provider to the logged in
+// user, so that they can log in through the provider from then on. It's a POST so that another site can't
+// start linking the user to an identity of its own choosing.
+func (app *application) accountLinkOIDCPost(w http.ResponseWriter, r *http.Request) {
+	provider := app.oidcProvider(r.PathValue("provider"))
+	if provider == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "oidcLinkUserID", app.authenticatedUser(r).ID)
+	app.redirectToOIDCProvider(w, r, provider)
+}
+
+// redirectToOIDCProvider starts the authorization code flow by redirecting the user to the provider. The
+// state, nonce and PKCE code verifier are stored in the session so that we can check them in the callback.
+func (app *application) redirectToOIDCProvider(w http.ResponseWriter, r *http.Request, provider *oidcProvider) {
+	state, err := random.Token()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	nonce, err := random.Token()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	verifier := oauth2.GenerateVerifier()
+
+	app.sessionManager.Put(r.Context(), "oidcProvider", provider.Name)
+	app.sessionManager.Put(r.Context(), "oidcState", state)
+	app.sessionManager.Put(r.Context(), "oidcNonce", nonce)
+	app.sessionManager.Put(r.Context(), "oidcVerifier", verifier)
+
+	url := provider.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// oidcClaims holds the ID token claims that we use to find or create the local user.
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Nonce         string `json:"nonce"`
+}
+
+// userLoginOIDCCallback completes the authorization code flow. It exchanges the code for tokens, verifies the
+// ID token and then logs in the local user linked to the identity, creating them if necessary. If the flow
+// was started from the account page, the identity is linked to the logged in user instead.
+func (app *application) userLoginOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider := app.oidcProvider(r.PathValue("provider"))
+	if provider == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Pop the values out of the session so that the callback can't be replayed.
+	providerName := app.sessionManager.PopString(r.Context(), "oidcProvider")
+	state := app.sessionManager.PopString(r.Context(), "oidcState")
+	nonce := app.sessionManager.PopString(r.Context(), "oidcNonce")
+	verifier := app.sessionManager.PopString(r.Context(), "oidcVerifier")
+	linkUserID := app.sessionManager.PopInt(r.Context(), "oidcLinkUserID")
+
+	if providerName != provider.Name || state == "" || r.URL.Query().Get("state") != state {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// The provider redirects back with an error parameter if the user declined or the request was invalid.
+	if errParam := r.URL.Query().Get("error"); errParam != "" {
+		app.logger.WarnContext(r.Context(), "oidc login failed", "provider", provider.Name, "error", errParam)
+		app.oidcLoginFailed(w, r, "Single sign-on failed. Please try again.")
+		return
+	}
+
+	token, err := provider.oauth2.Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		app.logger.WarnContext(r.Context(), "oidc code exchange failed", "provider", provider.Name, "error", err.Error())
+		app.oidcLoginFailed(w, r, "Single sign-on failed. Please try again.")
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		app.serverError(w, r, errors.New("oidc token response did not contain an id_token"))
+		return
+	}
+
+	idToken, err := provider.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		app.logger.WarnContext(r.Context(), "oidc id token verification failed", "provider", provider.Name, "error", err.Error())
+		app.oidcLoginFailed(w, r, "Single sign-on failed. Please try again.")
+		return
+	}
+
+	var claims oidcClaims
+	err = idToken.Claims(&claims)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if claims.Nonce != nonce {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	if linkUserID != 0 {
+		app.linkOIDCIdentity(w, r, provider, idToken.Subject, linkUserID)
+		return
+	}
+
+	// We only trust email addresses which the provider has verified, otherwise anyone could link themselves
+	// to an existing local account by setting its email address at the provider.
+	if claims.Email == "" || !claims.EmailVerified {
+		app.oidcLoginFailed(w, r, "Your single sign-on account does not have a verified email address.")
+		return
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = claims.Email
+	}
+
+	id, err := app.users.UpsertExternal(r.Context(), provider.Name, idToken.Subject, name, claims.Email)
+	if err != nil {
+		if errors.Is(err, models.ErrLocalAccount) {
+			app.oidcLoginFailed(w, r, "An account with your email address already exists. Log in with your password, then link single sign-on from your account page.")
+		} else if errors.Is(err, models.ErrDuplicateEmail) {
+			app.oidcLoginFailed(w, r, "Your email address is already in use by another account.")
+		} else if errors.Is(err, models.ErrAccountDisabled) {
+			app.oidcLoginFailed(w, r, "Your account has been disabled.")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.startAuthenticatedSession(r, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.metrics.loginsSucceeded.WithLabelValues("oidc").Inc()
+
+	http.Redirect(w, r, app.url("/snippet/create/"), http.StatusSeeOther)
+}
+
+// linkOIDCIdentity links an identity at a provider to the user who started linking it from their account page.
+func (app *application) linkOIDCIdentity(w http.ResponseWriter, r *http.Request, provider *oidcProvider, subject string, userID int) {
+	// The user must still be logged in as the same person who started linking.
+	if app.authenticatedUser(r).ID != userID {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err := app.users.LinkExternal(r.Context(), provider.Name, subject, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrIdentityInUse) {
+			app.sessionManager.Put(r.Context(), "flash", "That "+provider.DisplayName+" account is already linked to another user.")
+			http.Redirect(w, r, app.url("/account"), http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.logger.InfoContext(r.Context(), "Linked external identity", "provider", provider.Name, "user", userID)
+	app.sessionManager.Put(r.Context(), "flash", "Your "+provider.DisplayName+" account has been linked. You can now use it to log in.")
+	http.Redirect(w, r, app.url("/account"), http.StatusSeeOther)
+}
+
+// oidcLoginFailed sends the user back to the login page with a flash message explaining what went wrong.
+func (app *application) oidcLoginFailed(w http.ResponseWriter, r *http.Request, message string) {
+	app.metrics.loginsFailed.WithLabelValues("oidc", "rejected").Inc()
+	app.sessionManager.Put(r.Context(), "flash", message)
+	http.Redirect(w, r, app.url("/user/login"), http.StatusSeeOther)
+}
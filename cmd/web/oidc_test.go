@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+// mockIssuer is a minimal OpenID Connect provider which supports discovery, a JWKS endpoint and a token
+// endpoint that issues RS256 signed ID tokens. The claims in the issued ID token are taken from the fields of
+// the mockIssuer, so tests can change them before completing a login.
+type mockIssuer struct {
+	*httptest.Server
+	key           *rsa.PrivateKey
+	nonce         string
+	codeChallenge string
+	email         string
+	emailVerified bool
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockIssuer{key: key, email: "alice@example.com", emailVerified: true}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, map[string]any{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(t, w, map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		// Check the PKCE code verifier matches the challenge that was sent to the authorization endpoint.
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != m.codeChallenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		writeJSON(t, w, map[string]any{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     m.signIDToken(t),
+		})
+	})
+
+	m.Server = httptest.NewServer(mux)
+	return m
+}
+
+func (m *mockIssuer) signIDToken(t *testing.T) string {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := json.Marshal(map[string]any{
+		"iss":            m.URL,
+		"sub":            "alice",
+		"aud":            "snippetbox",
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"nonce":          m.nonce,
+		"name":           "Alice Jones",
+		"email":          m.email,
+		"email_verified": m.emailVerified,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signingInput))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// provider returns an oidcProvider named "mock" which uses the issuer.
+func (m *mockIssuer) provider(t *testing.T) *oidcProvider {
+	provider, err := newOIDCProvider(context.Background(), oidcProviderConfig{
+		Name:        "mock",
+		DisplayName: "Mock SSO",
+		Issuer:      m.URL,
+		ClientID:    "snippetbox",
+		RedirectURL: "https://snippetbox.test/user/login/oidc/mock/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return provider
+}
+
+// authorize reads the nonce and PKCE challenge from a redirect to the issuer's authorization endpoint, so
+// that they're included in the next ID token, and returns the state.
+func (m *mockIssuer) authorize(t *testing.T, header http.Header) string {
+	location, err := url.Parse(header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, strings.HasPrefix(location.String(), m.URL+"/authorize"), true)
+
+	m.nonce = location.Query().Get("nonce")
+	m.codeChallenge = location.Query().Get("code_challenge")
+	return location.Query().Get("state")
+}
+
+func writeJSON(t *testing.T, w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserLoginOIDC(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.Close()
+
+	tests := []struct {
+		name          string
+		email         string
+		emailVerified bool
+		state         string
+		wantCode      int
+		wantLocation  string
+	}{
+		{
+			name:          "Valid login",
+			email:         "bob@example.com",
+			emailVerified: true,
+			wantCode:      http.StatusSeeOther,
+			wantLocation:  "/snippet/create/",
+		},
+		{
+			name:          "Local account with the same email",
+			email:         "alice@example.com",
+			emailVerified: true,
+			wantCode:      http.StatusSeeOther,
+			wantLocation:  "/user/login",
+		},
+		{
+			name:          "Unverified email",
+			email:         "alice@example.com",
+			emailVerified: false,
+			wantCode:      http.StatusSeeOther,
+			wantLocation:  "/user/login",
+		},
+		{
+			name:          "Duplicate email",
+			email:         "dupe@example.com",
+			emailVerified: true,
+			wantCode:      http.StatusSeeOther,
+			wantLocation:  "/user/login",
+		},
+		{
+			name:          "Invalid state",
+			email:         "alice@example.com",
+			emailVerified: true,
+			state:         "wrong-state",
+			wantCode:      http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.oidcProviders = []*oidcProvider{issuer.provider(t)}
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			_, _, body := ts.get(t, "/user/login")
+			assert.StringContains(t, body, "Mock SSO")
+
+			// Start the login, and pick the state, nonce and PKCE challenge out of the redirect to the
+			// provider's authorization endpoint.
+			code, header, _ := ts.get(t, "/user/login/oidc/mock")
+			assert.Equal(t, code, http.StatusFound)
+
+			location, err := url.Parse(header.Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, strings.HasPrefix(location.String(), issuer.URL+"/authorize"), true)
+			assert.Equal(t, location.Query().Get("code_challenge_method"), "S256")
+
+			issuer.nonce = location.Query().Get("nonce")
+			issuer.codeChallenge = location.Query().Get("code_challenge")
+			issuer.email = tt.email
+			issuer.emailVerified = tt.emailVerified
+
+			state := location.Query().Get("state")
+			if tt.state != "" {
+				state = tt.state
+			}
+
+			code, header, _ = ts.get(t, "/user/login/oidc/mock/callback?code=abc&state="+url.QueryEscape(state))
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+		})
+	}
+}
+
+func TestAccountLinkOIDC(t *testing.T) {
+	issuer := newMockIssuer(t)
+	defer issuer.Close()
+
+	app := newTestApplication(t)
+	app.oidcProviders = []*oidcProvider{issuer.provider(t)}
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// Alice signed up with a password, so she can't log in through the provider with the same email address
+	// until she has linked it.
+	code, header, _ := ts.get(t, "/user/login/oidc/mock")
+	assert.Equal(t, code, http.StatusFound)
+	state := issuer.authorize(t, header)
+
+	code, header, _ = ts.get(t, "/user/login/oidc/mock/callback?code=abc&state="+url.QueryEscape(state))
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+
+	_, _, body := ts.get(t, "/user/login")
+	assert.StringContains(t, body, "Log in with your password, then link single sign-on from your account page.")
+
+	ts.login(t)
+
+	_, _, body = ts.get(t, "/account")
+	assert.StringContains(t, body, "alice@example.com")
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, header, _ = ts.postForm(t, "/account/sso/mock", form)
+	assert.Equal(t, code, http.StatusFound)
+	state = issuer.authorize(t, header)
+
+	code, header, _ = ts.get(t, "/user/login/oidc/mock/callback?code=abc&state="+url.QueryEscape(state))
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account")
+
+	_, _, body = ts.get(t, "/account")
+	assert.StringContains(t, body, "Your Mock SSO account has been linked.")
+
+	// Linking needs a CSRF token, so other sites can't start it.
+	code, _, _ = ts.postForm(t, "/account/sso/mock", url.Values{})
+	assert.Equal(t, code, http.StatusBadRequest)
+}
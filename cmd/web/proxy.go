@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// validBasePath matches base paths made up of segments of unreserved URL characters.
+var validBasePath = regexp.MustCompile(`^(/[A-Za-z0-9._~-]+)+$`)
+
+// parseBasePath normalizes the URL path prefix which the application is mounted under, so that it starts
+// with a slash and doesn't end with one. The root path is returned as the empty string.
+func parseBasePath(s string) (string, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "/")
+	if s == "" {
+		return "", nil
+	}
+
+	if !validBasePath.MatchString(s) {
+		return "", fmt.Errorf("invalid base path %q", s)
+	}
+
+	return s, nil
+}
+
+// cookiePath returns the path for our cookies, so that they're only sent to the application.
+func cookiePath(basePath string) string {
+	if basePath == "" {
+		return "/"
+	}
+	return basePath + "/"
+}
+
+// url returns the URL of a path within the application, including the base path. It's used for redirects
+// and in the templates, as the paths our handlers see have had the base path removed.
+func (app *application) url(path string) string {
+	return app.basePath + path
+}
+
+// stripBasePath removes the base path from the request URL before passing the request on, so that the
+// routes don't need to know about it. Requests outside the base path are not found. Like serveWithContext,
+// it copies the matched route pattern back onto the original request for the middleware before it.
+func (app *application) stripBasePath(next http.Handler) http.Handler {
+	if app.basePath == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == app.basePath {
+			target := app.basePath + "/"
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+
+		path, ok := strings.CutPrefix(r.URL.Path, app.basePath)
+		if !ok || !strings.HasPrefix(path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = path
+		r2.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, app.basePath)
+		defer func() { r.Pattern = r2.Pattern }()
+
+		next.ServeHTTP(&basePathWriter{ResponseWriter: w, basePath: app.basePath}, r2)
+	})
+}
+
+// basePathWriter wraps a http.ResponseWriter, adding the base path to the Location header. Our handlers use
+// app.url for their redirects, but http.ServeMux redirects to the stripped paths it sees, like from
+// "/snippet/create" to "/snippet/create/", which would take the client out of the application.
+type basePathWriter struct {
+	http.ResponseWriter
+	basePath    string
+	wroteHeader bool
+}
+
+func (bw *basePathWriter) WriteHeader(code int) {
+	if !bw.wroteHeader {
+		bw.fixLocation()
+		bw.wroteHeader = code < 100 || code >= 200
+	}
+	bw.ResponseWriter.WriteHeader(code)
+}
+
+func (bw *basePathWriter) Write(b []byte) (int, error) {
+	if !bw.wroteHeader {
+		bw.fixLocation()
+		bw.wroteHeader = true
+	}
+	return bw.ResponseWriter.Write(b)
+}
+
+// fixLocation adds the base path to a Location header which is an absolute path outside of it. Locations
+// which are relative, on another host or already within the base path are left alone.
+func (bw *basePathWriter) fixLocation() {
+	h := bw.Header()
+	location := h.Get("Location")
+	if !strings.HasPrefix(location, "/") || strings.HasPrefix(location, "//") {
+		return
+	}
+
+	if location == bw.basePath || strings.HasPrefix(location, bw.basePath+"/") || strings.HasPrefix(location, bw.basePath+"?") {
+		return
+	}
+
+	h.Set("Location", bw.basePath+location)
+}
+
+// Unwrap returns the underlying http.ResponseWriter, so that http.ResponseController can reach it.
+func (bw *basePathWriter) Unwrap() http.ResponseWriter {
+	return bw.ResponseWriter
+}
+
+// forwardedFor returns the chain of client and proxy addresses which the request was forwarded through,
+// from the standard Forwarded header if it has one, or the X-Forwarded-For header if not. Addresses which
+// aren't IP addresses (like "unknown", or an obfuscated identifier) are returned as they are, and the port
+// numbers are removed.
+func forwardedFor(r *http.Request) []string {
+	var chain []string
+
+	if values := r.Header.Values("Forwarded"); len(values) > 0 {
+		for _, element := range forwardedElements(values) {
+			chain = append(chain, stripPort(element["for"]))
+		}
+		return chain
+	}
+
+	for _, addr := range strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",") {
+		chain = append(chain, strings.TrimSpace(addr))
+	}
+	return chain
+}
+
+// forwardedElements parses the Forwarded header values described in RFC 7239, like
+// `for=192.0.2.60;proto=https, for="[2001:db8::1]:4711"`, into a map of parameters for each element.
+func forwardedElements(values []string) []map[string]string {
+	var elements []map[string]string
+
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			params := map[string]string{}
+			for _, pair := range strings.Split(element, ";") {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				params[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+			elements = append(elements, params)
+		}
+	}
+
+	return elements
+}
+
+// stripPort removes the port number from an address, and the brackets around an IPv6 address.
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.Trim(addr, "[]")
+}
+
+// requestScheme returns the scheme ("http" or "https") which the client used to make the request. If we
+// didn't terminate TLS ourselves and the request came from a trusted proxy, we use the protocol that the
+// proxy says the client used. The proxy adds its own header value last, so we take the last one.
+func (app *application) requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil || !app.isTrustedProxy(ip) {
+		return "http"
+	}
+
+	var proto string
+	if values := r.Header.Values("Forwarded"); len(values) > 0 {
+		elements := forwardedElements(values)
+		proto = elements[len(elements)-1]["proto"]
+	} else if values := r.Header.Values("X-Forwarded-Proto"); len(values) > 0 {
+		protos := strings.Split(values[len(values)-1], ",")
+		proto = protos[len(protos)-1]
+	}
+
+	if strings.EqualFold(strings.TrimSpace(proto), "https") {
+		return "https"
+	}
+	return "http"
+}
+
+// isHTTPS returns true if the client made the request over HTTPS.
+func (app *application) isHTTPS(r *http.Request) bool {
+	return app.requestScheme(r) == "https"
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+func TestParseBasePath(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: "", want: ""},
+		{input: "/", want: ""},
+		{input: "/snippetbox", want: "/snippetbox"},
+		{input: "/apps/snippetbox/", want: "/apps/snippetbox"},
+		{input: "snippetbox", wantErr: true},
+		{input: "/snippet box", wantErr: true},
+		{input: "/snippetbox?x=y", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseBasePath(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error for %q", tt.input)
+				}
+				return
+			}
+			assert.NilError(t, err)
+			assert.Equal(t, got, tt.want)
+		})
+	}
+}
+
+func TestRequestScheme(t *testing.T) {
+	app := newTestApplication(t)
+
+	trustedProxies, err := parseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.trustedProxies = trustedProxies
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "Direct connection",
+			remoteAddr: "203.0.113.5:1234",
+			want:       "http",
+		},
+		{
+			name:       "Untrusted proxy",
+			remoteAddr: "203.0.113.5:1234",
+			headers:    map[string]string{"X-Forwarded-Proto": "https"},
+			want:       "http",
+		},
+		{
+			name:       "X-Forwarded-Proto",
+			remoteAddr: "10.1.2.3:1234",
+			headers:    map[string]string{"X-Forwarded-Proto": "https"},
+			want:       "https",
+		},
+		{
+			name:       "Last X-Forwarded-Proto wins",
+			remoteAddr: "10.1.2.3:1234",
+			headers:    map[string]string{"X-Forwarded-Proto": "https, http"},
+			want:       "http",
+		},
+		{
+			name:       "Forwarded",
+			remoteAddr: "10.1.2.3:1234",
+			headers:    map[string]string{"Forwarded": "for=198.51.100.7;proto=https"},
+			want:       "https",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+
+			assert.Equal(t, app.requestScheme(r), tt.want)
+		})
+	}
+}
+
+func TestNoSurfSecureCookie(t *testing.T) {
+	app := newTestApplication(t)
+
+	trustedProxies, err := parseTrustedProxies("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.trustedProxies = trustedProxies
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	for _, proto := range []string{"http", "https"} {
+		t.Run(proto, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = "10.1.2.3:1234"
+			r.Header.Set("X-Forwarded-Proto", proto)
+
+			app.noSurf(next).ServeHTTP(rr, r)
+
+			cookies := rr.Result().Cookies()
+			if len(cookies) != 1 {
+				t.Fatalf("got %d cookies; want 1", len(cookies))
+			}
+			assert.Equal(t, cookies[0].Secure, proto == "https")
+		})
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	app := newTestApplication(t)
+	app.basePath = "/snippetbox"
+
+	templateCache, err := newTemplateCache(app.staticAssets, app.basePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.templateCache = templateCache
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/snippetbox/")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `<a href="/snippetbox/snippet/view/1">`)
+	assert.StringContains(t, body, `href="/snippetbox/static/css/main.`)
+
+	code, _, _ = ts.get(t, "/snippetbox/static/css/main.css")
+	assert.Equal(t, code, http.StatusOK)
+
+	code, header, _ := ts.get(t, "/snippetbox")
+	assert.Equal(t, code, http.StatusMovedPermanently)
+	assert.Equal(t, header.Get("Location"), "/snippetbox/")
+
+	code, _, _ = ts.get(t, "/snippet/view/1")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	code, _, _ = ts.get(t, "/snippetboxes/")
+	assert.Equal(t, code, http.StatusNotFound)
+
+	// Redirects and cookies stay within the base path, including the ones that http.ServeMux makes to add
+	// a trailing slash.
+	code, header, _ = ts.get(t, "/snippetbox/snippet/create")
+	assert.Equal(t, code, http.StatusTemporaryRedirect)
+	assert.Equal(t, header.Get("Location"), "/snippetbox/snippet/create/")
+
+	code, header, _ = ts.get(t, "/snippetbox/snippet/create/")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/snippetbox/user/login")
+
+	for _, cookie := range header.Values("Set-Cookie") {
+		if !strings.Contains(cookie, "Path=/snippetbox/") {
+			t.Errorf("cookie %q isn't limited to the base path", cookie)
+		}
+	}
+}
@@ -2,9 +2,21 @@ package main
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/justinas/alice"
-	"github.com/vishal-rfx/snippetbox/ui"
+	"github.com/vishal-rfx/snippetbox/internal/models"
+	"github.com/vishal-rfx/snippetbox/internal/ratelimit"
+)
+
+// Rate limit policies for each group of routes. Every client gets a generous limit across the site's pages,
+// with much stricter limits on logging in and signing up (to slow down password guessing) and on creating
+// snippets and posting comments.
+var (
+	defaultRateLimit = ratelimit.Policy{Limit: 300, Period: time.Minute}
+	authRateLimit    = ratelimit.Policy{Limit: 10, Period: time.Minute}
+	createRateLimit  = ratelimit.Policy{Limit: 10, Period: 10 * time.Minute}
+	commentRateLimit = ratelimit.Policy{Limit: 20, Period: 10 * time.Minute}
 )
 
 // routes method returns a servemux containing application routes.
@@ -14,40 +26,106 @@ func (app *application) routes() http.Handler {
 	// register the home function as the handler for the "/" URL pattern.
 	mux := http.NewServeMux()
 
-	// Use the http.FileServerFS() function to create a HTTP handler which
-	// serves the embedded files in ui.Files. It's important to note that our static files are 
-	// contained in the static folder of the ui.Files embedded filesystem. So, for example, our CSS stylesheet is located at 
-	// "static/css/main.css".
-	mux.Handle("GET /static/", http.FileServerFS(ui.Files))
+	// Serve the embedded files in the static folder of ui.Files, which were loaded into memory (and
+	// precompressed) when the application started. So, for example, our CSS stylesheet is served at
+	// "/static/css/main.css".
+	mux.HandleFunc("GET /static/", app.serveStatic)
 	
 	// Add a new GET /ping route.
 	mux.HandleFunc("GET /ping", ping)
 
+	// Add the health check routes for load balancers and orchestrators.
+	mux.HandleFunc("GET /healthz", app.healthz)
+	mux.HandleFunc("GET /readyz", app.readyz)
+
+	// Add the endpoint which browsers send Content-Security-Policy violation reports to. It doesn't use the
+	// dynamic middleware, as the reports don't carry a session or CSRF token, so it's rate limited by IP address.
+	mux.Handle("POST /csp-report", app.rateLimit("default", defaultRateLimit)(http.HandlerFunc(app.cspReport)))
+
 	// Create a new middleware chain containing the middleware specific to our dynamic application routes.
-	// For now, this chain will only contain the LoadAndSave session middleware but we'll add more to it later.
-	dynamic := alice.New(app.sessionManager.LoadAndSave, noSurf, app.authenticate)
+	// Each middleware is wrapped in its own tracing span, as is each handler. The default rate limit comes
+	// after authenticate so that logged in users are limited by their user ID rather than their IP address.
+	// Static files and health checks don't use this chain, so they aren't counted against the limit.
+	dynamic := alice.New(
+		traceMiddleware("LoadAndSave", app.sessionManager.LoadAndSave),
+		traceMiddleware("noSurf", app.noSurf),
+		traceMiddleware("authenticate", app.authenticate),
+		app.rateLimit("default", defaultRateLimit),
+	)
 
 	// Update these routes to use the new dynamic middleware chain followed by the
 	// appropriate handler function. Because alice ThenFunc() method returns a http.Handler (rather than a http.HandlerFunc)
 	// we also need to switch to registering the route using the mux.Handle() method.
 
-	mux.Handle("GET /{$}", dynamic.ThenFunc(app.home))
-	mux.Handle("GET /snippet/view/{id}", dynamic.ThenFunc(app.snippetView))
-	mux.Handle("GET /user/signup", dynamic.ThenFunc(app.userSignup))
-	mux.Handle("POST /user/signup", dynamic.ThenFunc(app.userSignupPost))
-	mux.Handle("GET /user/login", dynamic.ThenFunc(app.userLogin))
-	mux.Handle("POST /user/login", dynamic.ThenFunc(app.userLoginPost))
+	mux.Handle("GET /{$}", dynamic.ThenFunc(traceHandler(app.home)))
+	mux.Handle("GET /snippet/view/{id}", dynamic.ThenFunc(traceHandler(app.snippetView)))
+	mux.Handle("GET /snippet/view/{id}/{file}", dynamic.ThenFunc(traceHandler(app.snippetFileView)))
+	mux.Handle("GET /snippet/raw/{id}/{file}", dynamic.ThenFunc(traceHandler(app.snippetFileRaw)))
+	mux.Handle("GET /snippet/archive/{archive}", dynamic.ThenFunc(traceHandler(app.snippetArchive)))
+	mux.Handle("GET /tags", dynamic.ThenFunc(traceHandler(app.tags)))
+	mux.Handle("GET /tags/{tag}", dynamic.ThenFunc(traceHandler(app.tagView)))
+	mux.Handle("GET /user/signup", dynamic.ThenFunc(traceHandler(app.userSignup)))
+	mux.Handle("GET /user/login", dynamic.ThenFunc(traceHandler(app.userLogin)))
+
+	auth := dynamic.Append(app.rateLimit("auth", authRateLimit))
+	mux.Handle("POST /user/signup", auth.ThenFunc(traceHandler(app.userSignupPost)))
+	mux.Handle("POST /user/login", auth.ThenFunc(traceHandler(app.userLoginPost)))
+	mux.Handle("GET /user/login/oidc/{provider}", auth.ThenFunc(traceHandler(app.userLoginOIDC)))
+	mux.Handle("GET /user/login/oidc/{provider}/callback", auth.ThenFunc(traceHandler(app.userLoginOIDCCallback)))
+
+	// Users who have been forced to reset their password can only change it or log out, so those routes use
+	// their own chain without the requirePasswordCurrent middleware. Users who logged in with a client
+	// certificate have no session, so they can't use the account pages for their password or sessions.
+	passwordReset := dynamic.Append(app.requireAuthentication)
+	mux.Handle("POST /user/logout", passwordReset.ThenFunc(traceHandler(app.userLogoutPost)))
+	mux.Handle("GET /account/password", passwordReset.Append(app.requireSession).ThenFunc(traceHandler(app.accountPasswordUpdate)))
+	mux.Handle("POST /account/password", passwordReset.Append(app.requireSession).ThenFunc(traceHandler(app.accountPasswordUpdatePost)))
+
+	protected := passwordReset.Append(app.requirePasswordCurrent)
+	mux.Handle("GET /snippet/create/{$}", protected.ThenFunc(traceHandler(app.snippetCreate)))
+	mux.Handle("POST /snippet/create/{$}", protected.Append(app.rateLimit("create", createRateLimit)).ThenFunc(traceHandler(app.snippetCreatePost)))
+	mux.Handle("POST /snippet/comment/{id}", protected.Append(app.rateLimit("comment", commentRateLimit)).ThenFunc(traceHandler(app.commentCreatePost)))
+	mux.Handle("GET /comment/edit/{id}", protected.ThenFunc(traceHandler(app.commentEdit)))
+	mux.Handle("POST /comment/edit/{id}", protected.Append(app.rateLimit("comment", commentRateLimit)).ThenFunc(traceHandler(app.commentEditPost)))
+	mux.Handle("POST /comment/delete/{id}", protected.ThenFunc(traceHandler(app.commentDeletePost)))
+
+	sessions := protected.Append(app.requireSession)
+	mux.Handle("GET /account", sessions.ThenFunc(traceHandler(app.account)))
+	mux.Handle("POST /account/sso/{provider}", sessions.ThenFunc(traceHandler(app.accountLinkOIDCPost)))
+	mux.Handle("GET /account/sessions", sessions.ThenFunc(traceHandler(app.accountSessions)))
+	mux.Handle("POST /account/sessions/revoke/{id}", sessions.ThenFunc(traceHandler(app.accountSessionRevokePost)))
+	mux.Handle("POST /account/sessions/revoke-others", sessions.ThenFunc(traceHandler(app.accountSessionsRevokeOthersPost)))
+
+	mux.Handle("GET /account/export.zip", protected.ThenFunc(traceHandler(app.accountExport)))
+	mux.Handle("GET /account/export.tar.gz", protected.ThenFunc(traceHandler(app.accountExport)))
 
-	protected := dynamic.Append(app.requireAuthentication)
-	mux.Handle("GET /snippet/create/{$}", protected.ThenFunc(app.snippetCreate))
-	mux.Handle("POST /snippet/create/{$}",protected.ThenFunc(app.snippetCreatePost))
-	mux.Handle("POST /user/logout", protected.ThenFunc(app.userLogoutPost))
+	// Moderators can delete any snippet and moderate comments, and admins can also manage users.
+	moderator := protected.Append(app.requireRole(models.RoleModerator))
+	mux.Handle("POST /snippet/delete/{id}", moderator.ThenFunc(traceHandler(app.snippetDeletePost)))
+	mux.Handle("POST /comment/moderate/{id}", moderator.ThenFunc(traceHandler(app.commentModeratePost)))
 
+	admin := protected.Append(app.requireRole(models.RoleAdmin))
+	mux.Handle("GET /admin", admin.ThenFunc(traceHandler(app.adminUsers)))
+	mux.Handle("POST /admin/users/{id}/role", admin.ThenFunc(traceHandler(app.adminUserRolePost)))
+	mux.Handle("POST /admin/users/{id}/disable", admin.ThenFunc(traceHandler(app.adminUserDisablePost)))
+	mux.Handle("POST /admin/users/{id}/enable", admin.ThenFunc(traceHandler(app.adminUserEnablePost)))
+	mux.Handle("POST /admin/users/{id}/password-reset", admin.ThenFunc(traceHandler(app.adminUserPasswordResetPost)))
 
 	// Create a middleware chain containing our 'standard' middleware which will be used for every request our 
-	// application receives
-	standard := alice.New(app.recoverPanic, app.logRequest, commonHeaders)
+	// application receives. The requestID and traceRequest middleware come first so that the request ID and
+	// trace are available to everything else.
+	standard := alice.New(
+		app.requestID,
+		app.traceRequest,
+		app.instrumentRequest,
+		traceMiddleware("recoverPanic", app.recoverPanic),
+		traceMiddleware("logRequest", app.logRequest),
+		compressResponse,
+		app.commonHeaders,
+	)
 
-	return standard.Then(mux)
+	// The base path is removed from the URL after the standard middleware, so that the request logs show the
+	// full path which the client asked for.
+	return standard.Then(app.stripBasePath(mux))
 
 }
\ No newline at end of file
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	minifier "github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/css"
+	"github.com/tdewolff/minify/v2/js"
+)
+
+// staticAssetMaxAge is how long browsers can cache assets requested by their fingerprinted URL. A year is
+// the longest that is widely supported, and the content at the URL will never change.
+const staticAssetMaxAge = 365 * 24 * time.Hour
+
+// staticAsset is a file from the static directory, held in memory along with its precompressed versions.
+// The gzip and brotli fields are nil if the file isn't worth compressing. The url is the fingerprinted URL
+// of the file, which includes part of the hash of its content (like "/static/css/main.1a2b3c4d5e6f.css").
+type staticAsset struct {
+	content     []byte
+	gzip        []byte
+	brotli      []byte
+	hash        string
+	contentType string
+	url         string
+}
+
+// loadStaticAssets reads the files in the static directory of fsys, keyed by both their path (like
+// "static/css/main.css") and their fingerprinted path. If minify is true, CSS and JavaScript files are
+// minified. Compressible files are compressed once here with the best compression settings, which would be
+// too slow to use for every request.
+func loadStaticAssets(fsys fs.FS, minify bool) (map[string]*staticAsset, error) {
+	assets := map[string]*staticAsset{}
+
+	var m *minifier.M
+	if minify {
+		m = minifier.New()
+		m.AddFunc("text/css", css.Minify)
+		m.AddFunc("text/javascript", js.Minify)
+	}
+
+	err := fs.WalkDir(fsys, "static", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		a, err := newStaticAsset(name, b, m)
+		if err != nil {
+			return err
+		}
+
+		assets[name] = a
+		assets[strings.TrimPrefix(a.url, "/")] = a
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return assets, nil
+}
+
+func newStaticAsset(name string, content []byte, m *minifier.M) (*staticAsset, error) {
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(content)
+	}
+
+	if m != nil {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		minified, err := m.Bytes(mediaType, content)
+		switch {
+		case err == nil:
+			content = minified
+		case !errors.Is(err, minifier.ErrNotExist):
+			return nil, fmt.Errorf("minifying %s: %w", name, err)
+		}
+	}
+
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	ext := path.Ext(name)
+
+	a := &staticAsset{
+		content:     content,
+		hash:        hash,
+		contentType: contentType,
+		url:         "/" + strings.TrimSuffix(name, ext) + "." + hash[:12] + ext,
+	}
+
+	if len(content) < compressMinSize || !compressibleType(a.contentType) {
+		return a, nil
+	}
+
+	var buf bytes.Buffer
+	gw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	gw.Write(content)
+	err = gw.Close()
+	if err != nil {
+		return nil, err
+	}
+	a.gzip = bytes.Clone(buf.Bytes())
+
+	buf.Reset()
+	bw := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	bw.Write(content)
+	err = bw.Close()
+	if err != nil {
+		return nil, err
+	}
+	a.brotli = bytes.Clone(buf.Bytes())
+
+	return a, nil
+}
+
+// serveStatic serves the static assets. Assets requested by their fingerprinted URL can be cached forever,
+// as a change to the file changes its URL. Otherwise the response has an ETag, so that browsers can check
+// whether their cached copy is current (the embedded files have no modification time to do this with).
+// The precompressed version of the file is sent if the browser accepts it.
+func (app *application) serveStatic(w http.ResponseWriter, r *http.Request) {
+	a, ok := app.staticAssets[strings.TrimPrefix(r.URL.Path, "/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, etag := a.content, a.hash[:32]
+
+	switch negotiateEncoding(r.Header.Get("Accept-Encoding")) {
+	case "br":
+		if a.brotli != nil {
+			body, etag = a.brotli, etag+"-br"
+			w.Header().Set("Content-Encoding", "br")
+		}
+	case "gzip":
+		if a.gzip != nil {
+			body, etag = a.gzip, etag+"-gzip"
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+	}
+
+	w.Header().Set("Content-Type", a.contentType)
+	w.Header().Set("ETag", `"`+etag+`"`)
+	if r.URL.Path == a.url {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, immutable", int(staticAssetMaxAge.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+}
+
+// assetFunc returns the asset template function, which takes the path of a file in the static directory
+// (like "css/main.css") and returns its fingerprinted URL under the base path.
+func assetFunc(assets map[string]*staticAsset, basePath string) func(name string) (string, error) {
+	return func(name string) (string, error) {
+		a, ok := assets["static/"+name]
+		if !ok {
+			return "", fmt.Errorf("unknown static asset %q", name)
+		}
+
+		return basePath + a.url, nil
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"testing"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+	"github.com/vishal-rfx/snippetbox/ui"
+)
+
+func TestStaticFingerprinting(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	url, err := assetFunc(app.staticAssets, "")("css/main.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, url, "/static/css/main."+app.staticAssets["static/css/main.css"].hash[:12]+".css")
+
+	_, err = assetFunc(app.staticAssets, "")("css/missing.css")
+	if err == nil {
+		t.Error("expected an error for a missing asset")
+	}
+
+	// Pages link to the fingerprinted URL.
+	_, _, body := ts.get(t, "/")
+	assert.StringContains(t, body, `<link rel="stylesheet" href="`+url+`">`)
+
+	// Which can be cached forever, unlike the plain URL.
+	code, header := ts.getWithHeaders(t, url)
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Cache-Control"), "public, max-age=31536000, immutable")
+
+	code, header = ts.getWithHeaders(t, "/static/css/main.css")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Cache-Control"), "no-cache")
+
+	code, _ = ts.getWithHeaders(t, "/static/css/main.0123456789ab.css")
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestLoadStaticAssetsMinify(t *testing.T) {
+	original, err := fs.ReadFile(ui.Files, "static/css/main.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := loadStaticAssets(ui.Files, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(plain["static/css/main.css"].content), string(original))
+
+	minified, err := loadStaticAssets(ui.Files, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(minified["static/css/main.css"].content) < len(original), true)
+
+	// Files which can't be minified are left alone.
+	assert.Equal(t, len(minified["static/img/logo.png"].content), len(plain["static/img/logo.png"].content))
+}
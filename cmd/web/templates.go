@@ -20,17 +20,28 @@ func humanDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
+// withComment returns a copy of the template data for rendering one comment with the "comment" template.
+func withComment(data templateData, c models.Comment) templateData {
+	data.Comment = c
+	return data
+}
+
 // Initialize a template.FuncMap object and store it in a global variable. This is essentially 
 // a string-keyed map which acts as a lookup between the names of our custom template functions and the 
 // functions themselves
 
 var functions = template.FuncMap{
 	"humanDate": humanDate,
+	"languageName": models.LanguageName,
+	"languages": func() []models.Language { return models.Languages },
+	"markdown": markdownLite,
+	"withComment": withComment,
 }
 
 
-// Create a template cache
-func newTemplateCache() (map[string]*template.Template, error) {
+// Create a template cache. The asset template function looks up the fingerprinted URLs of the static assets
+// in assets, and the url template function adds the base path to the path of a page, for links and forms.
+func newTemplateCache(assets map[string]*staticAsset, basePath string) (map[string]*template.Template, error) {
 	// Initialize a new map to act as the cache.
 	cache := map[string]*template.Template{}
 
@@ -54,7 +65,10 @@ func newTemplateCache() (map[string]*template.Template, error) {
 		// The template.FuncMap must be registered with the template set before we call the ParseFiles() method. This
 		// means we have to use the template.New() to create an empty template set, use the Funcs method to register the
 		// template.FuncMap, and then parse the file as normal.
-		ts, err := template.New(name).Funcs(functions).ParseFS(ui.Files, patterns...)
+		ts, err := template.New(name).Funcs(functions).Funcs(template.FuncMap{
+			"asset": assetFunc(assets, basePath),
+			"url":   func(path string) string { return basePath + path },
+		}).ParseFS(ui.Files, patterns...)
 		if err != nil {
 			return nil, err
 		}
@@ -79,6 +93,24 @@ type templateData struct {
 	Flash string // Add a Flash field to the templateData struct
 	IsAuthenticated bool // Add an IsAuthenticated field to the templateData struct
 	CSRFToken string
+	UserSessions []models.UserSession
+	CurrentSessionToken string
+	OIDCProviders []*oidcProvider
+	IsModerator bool
+	IsAdmin bool
+	User models.User
+	Users []models.User
+	Roles []models.Role
+	CSPNonce string
+	Tag string
+	TagCounts []models.TagCount
+	File string
+	Forks []models.Snippet
+	Comments []models.Comment
+	LineComments map[string]map[int][]models.Comment
+	Comment models.Comment
+	AuthenticatedUserID int
+	IsClientCertLogin bool
 }
 
 
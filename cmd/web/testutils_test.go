@@ -16,6 +16,8 @@ import (
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
 	"github.com/vishal-rfx/snippetbox/internal/models/mocks"
+	"github.com/vishal-rfx/snippetbox/internal/ratelimit"
+	"github.com/vishal-rfx/snippetbox/ui"
 )
 
 // Define a regular expression to match the CSRF token value in the HTML response body
@@ -37,10 +39,15 @@ func extractCSRFToken(t *testing.T, body string) string {
 // dependencies.
 func newTestApplication(t *testing.T) *application {
 	// Create an instance of template cache
-	templateCache, err := newTemplateCache()
+	staticAssets, err := loadStaticAssets(ui.Files, true)
 	if err != nil {
 		t.Fatal(err)
 	}
+	templateCache, err := newTemplateCache(staticAssets, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	// Add a form decoder
 	formDecoder := form.NewDecoder()
 
@@ -55,9 +62,19 @@ func newTestApplication(t *testing.T) *application {
 		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
 		snippets: &mocks.SnippetModel{},
 		users: &mocks.UserModel{},
+		userSessions: &mocks.UserSessionModel{},
+		rememberTokens: &mocks.RememberTokenModel{},
+		comments: &mocks.CommentModel{},
+		commentModerators: []commentModerator{linkLimitModerator{max: 3}, newBlocklistModerator("cheap ponds")},
 		templateCache: templateCache,
 		formDecoder: formDecoder,
 		sessionManager: sessionManager,
+		rememberLifetime: 30 * 24 * time.Hour,
+		archiveWriteTimeout: 5 * time.Minute,
+		rateLimiter: ratelimit.NewMemoryStore(),
+		metrics: newMetrics(nil),
+		staticAssets: staticAssets,
+		securityHeaders: defaultSecurityHeaders(),
 	}
 }
 
@@ -125,4 +142,27 @@ func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (i
 	body = bytes.TrimSpace(body)
 
 	return rs.StatusCode, rs.Header, string(body)
-}
\ No newline at end of file
+}
+
+// login logs in as the mocked user alice@example.com, so that subsequent requests made with the test server
+// client are authenticated.
+func (ts *testServer) login(t *testing.T) {
+	ts.loginAs(t, "alice@example.com")
+}
+
+// loginAs logs in as the mocked user with the given email address. The mocked admin user is
+// admin@example.com.
+func (ts *testServer) loginAs(t *testing.T, email string) {
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", "password")
+	form.Add("csrf_token", csrfToken)
+
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login failed with status %d", code)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certReloader holds the server's TLS certificate, and reloads it from disk when the certificate or key
+// file changes. Its GetCertificate method is used in the tls.Config, so a new certificate is used for new
+// connections as soon as it has been loaded, without restarting the server.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// newCertReloader loads the certificate and key from the given files.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile}
+
+	err := cr.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// GetCertificate implements the tls.Config GetCertificate callback.
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	return cr.cert, nil
+}
+
+// reload loads the certificate and key from disk. If they can't be loaded, the current certificate is kept.
+func (cr *certReloader) reload() error {
+	certMod, keyMod, err := cr.modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.certMod = certMod
+	cr.keyMod = keyMod
+	cr.mu.Unlock()
+
+	return nil
+}
+
+// reloadIfChanged reloads the certificate if either file has been modified since it was last loaded, and
+// reports whether it did. Tools like certbot replace the certificate and the key one after the other, so a
+// reload can fail because the files don't match yet. In that case we keep the old certificate and try
+// again next time.
+func (cr *certReloader) reloadIfChanged() (bool, error) {
+	certMod, keyMod, err := cr.modTimes()
+	if err != nil {
+		return false, err
+	}
+
+	cr.mu.RLock()
+	changed := !certMod.Equal(cr.certMod) || !keyMod.Equal(cr.keyMod)
+	cr.mu.RUnlock()
+
+	if !changed {
+		return false, nil
+	}
+
+	err = cr.reload()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (cr *certReloader) modTimes() (certMod, keyMod time.Time, err error) {
+	info, err := os.Stat(cr.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	certMod = info.ModTime()
+
+	info, err = os.Stat(cr.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	keyMod = info.ModTime()
+
+	return certMod, keyMod, nil
+}
+
+// watch checks the certificate files for changes every interval, until stop is closed.
+func (cr *certReloader) watch(interval time.Duration, logger *slog.Logger, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := cr.reloadIfChanged()
+			if err != nil {
+				logger.Error("reloading TLS certificate", "error", err.Error())
+			} else if reloaded {
+				logger.Info("Reloaded TLS certificate", "cert", cr.certFile)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// tlsOptions holds the command line settings for the server's TLS policy.
+type tlsOptions struct {
+	certFile     string
+	keyFile      string
+	minVersion   string
+	cipherSuites string
+	clientAuth   string
+	clientCAFile string
+}
+
+// newServerTLSConfig returns the TLS settings for the server, along with the reloader which provides its
+// certificate. We change the curve preferences value, so that only elliptic curves with assembly
+// implementations are used.
+func newServerTLSConfig(opts tlsOptions) (*tls.Config, *certReloader, error) {
+	certs, err := newCertReloader(opts.certFile, opts.keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	minVersion, err := parseTLSVersion(opts.minVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cipherSuites, err := parseCipherSuites(opts.cipherSuites)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clientAuth, err := parseClientAuth(opts.clientAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var clientCAs *x509.CertPool
+	if clientAuth != tls.NoClientCert {
+		if opts.clientCAFile == "" {
+			return nil, nil, errors.New("-client-ca-file is required for client certificate authentication")
+		}
+
+		clientCAs, err = loadCertPool(opts.clientCAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	tlsConfig := &tls.Config{
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		MinVersion:       minVersion,
+		CipherSuites:     cipherSuites,
+		GetCertificate:   certs.GetCertificate,
+		ClientAuth:       clientAuth,
+		ClientCAs:        clientCAs,
+	}
+
+	return tlsConfig, certs, nil
+}
+
+// parseTLSVersion parses a minimum TLS version given on the command line, like "1.2" or "1.3".
+func parseTLSVersion(s string) (uint16, error) {
+	switch s {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported minimum TLS version %q (use 1.2 or 1.3)", s)
+	}
+}
+
+// parseCipherSuites parses a comma-separated list of cipher suite names, like
+// "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256". Only the suites which Go considers secure are allowed. An
+// empty list returns nil, which means Go's default suites are used. The cipher suites only apply to TLS 1.2,
+// as TLS 1.3 suites aren't configurable.
+func parseCipherSuites(list string) ([]uint16, error) {
+	secure := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite.ID
+	}
+
+	var ids []uint16
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		id, ok := secure[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// redirectToHTTPS returns a handler which redirects every request to the same URL over HTTPS. The httpsAddr
+// is the address of the HTTPS server, whose port is used in the redirect unless it is the default port.
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
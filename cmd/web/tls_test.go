@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+// writeTestCert writes a new self-signed certificate and key for commonName to certFile and keyFile.
+func writeTestCert(t *testing.T, certFile, keyFile, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, "first")
+
+	cr, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commonName := func() string {
+		cert, err := cr.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+	assert.Equal(t, commonName(), "first")
+
+	reloaded, err := cr.reloadIfChanged()
+	assert.NilError(t, err)
+	assert.Equal(t, reloaded, false)
+
+	// A renewed certificate is picked up. Set the modification times explicitly, in case the files were
+	// written within the file system's timestamp resolution.
+	writeTestCert(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	os.Chtimes(keyFile, later, later)
+
+	reloaded, err = cr.reloadIfChanged()
+	assert.NilError(t, err)
+	assert.Equal(t, reloaded, true)
+	assert.Equal(t, commonName(), "second")
+
+	// A broken certificate is rejected, and the current one is kept.
+	err = os.WriteFile(certFile, []byte("not a certificate"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+
+	_, err = cr.reloadIfChanged()
+	if err == nil {
+		t.Error("expected an error for a broken certificate")
+	}
+	assert.Equal(t, commonName(), "second")
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	v, err := parseTLSVersion("1.3")
+	assert.NilError(t, err)
+	assert.Equal(t, v, uint16(tls.VersionTLS13))
+
+	_, err = parseTLSVersion("1.0")
+	if err == nil {
+		t.Error("expected an error for TLS 1.0")
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	ids, err := parseCipherSuites("")
+	assert.NilError(t, err)
+	assert.Equal(t, len(ids), 0)
+
+	ids, err = parseCipherSuites("TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384")
+	assert.NilError(t, err)
+	assert.Equal(t, len(ids), 2)
+	assert.Equal(t, ids[0], tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)
+
+	_, err = parseCipherSuites("TLS_RSA_WITH_RC4_128_SHA")
+	if err == nil {
+		t.Error("expected an error for an insecure cipher suite")
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		name      string
+		httpsAddr string
+		host      string
+		target    string
+		want      string
+	}{
+		{
+			name:      "Default port",
+			httpsAddr: ":443",
+			host:      "example.com",
+			target:    "/snippet/view/1?x=y",
+			want:      "https://example.com/snippet/view/1?x=y",
+		},
+		{
+			name:      "Custom port",
+			httpsAddr: ":4000",
+			host:      "example.com:80",
+			target:    "/",
+			want:      "https://example.com:4000/",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			r.Host = tt.host
+
+			redirectToHTTPS(tt.httpsAddr).ServeHTTP(rr, r)
+
+			assert.Equal(t, rr.Code, http.StatusMovedPermanently)
+			assert.Equal(t, rr.Header().Get("Location"), tt.want)
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer is used for all of the spans started by the web application. Like the tracer in the models package,
+// it uses the global tracer provider, which is a no-op unless newTracerProvider has installed a real one.
+var tracer = otel.Tracer("github.com/vishal-rfx/snippetbox/cmd/web")
+
+// newTracerProvider creates a tracer provider which sends spans to the given exporter ("stdout" or "otlp"),
+// and installs it as the global tracer provider along with the W3C Trace Context propagator. For the otlp
+// exporter, endpoint is the URL of the collector's OTLP/HTTP traces endpoint. The caller should shut the
+// provider down before exiting, so that any buffered spans are flushed.
+func newTracerProvider(exporter, endpoint string, sampleRatio float64) (*sdktrace.TracerProvider, error) {
+	var exp sdktrace.SpanExporter
+	var err error
+
+	switch exporter {
+	case "stdout":
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		exp, err = otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "snippetbox"))),
+		// Respect the sampling decision of the caller if there is one, so that we don't break up traces which
+		// started upstream.
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return tp, nil
+}
+
+// traceRequest is a middleware which starts the server span for each request. If the request has a
+// traceparent header then the span becomes part of the caller's trace. It should be the first middleware in
+// the chain, so that every other span is a child of it.
+func (app *application) traceRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("user_agent.original", r.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		rec := newResponseRecorder(w)
+		r = serveWithContext(rec, r, ctx, next)
+
+		// We only know the route once the servemux has matched the request, so the span is renamed afterwards.
+		if r.Pattern != "" {
+			span.SetName(r.Pattern)
+			span.SetAttributes(attribute.String("http.route", r.Pattern))
+		}
+
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// traceMiddleware wraps a middleware so that it runs in a span of its own with the given name. As the span
+// covers everything after the middleware in the chain too, the time spent in the middleware itself is the
+// gap between its span and its child span.
+func traceMiddleware(name string, mw func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		h := mw(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, span := tracer.Start(r.Context(), name)
+			defer span.End()
+
+			serveWithContext(w, r, ctx, h)
+		})
+	}
+}
+
+// traceHandler wraps a handler function so that it runs in its own span.
+func traceHandler(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracer.Start(r.Context(), "handler", trace.WithAttributes(attribute.String("http.route", r.Pattern)))
+		defer span.End()
+
+		fn(w, r.WithContext(ctx))
+	}
+}
+
+// serveWithContext calls h with a copy of r which carries ctx. The servemux records the matched route
+// pattern on the request it is given, so once h returns we copy the pattern back onto r for the benefit of
+// the middleware earlier in the chain (such as instrumentRequest), even if h panics. It returns the copy of
+// the request.
+func serveWithContext(w http.ResponseWriter, r *http.Request, ctx context.Context, h http.Handler) *http.Request {
+	r2 := r.WithContext(ctx)
+	defer func() { r.Pattern = r2.Pattern }()
+
+	h.ServeHTTP(w, r2)
+	return r2
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTraceRequest(t *testing.T) {
+	// The package level tracers delegate to the first tracer provider which is installed globally, so this
+	// should be the only test which installs one.
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/snippet/view/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	for _, name := range []string{"GET /snippet/view/{id}", "recoverPanic", "logRequest", "LoadAndSave", "authenticate", "handler", "render"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("no span named %q", name)
+		}
+
+		// Every span should be part of the trace from the traceparent header.
+		assert.Equal(t, span.SpanContext().TraceID().String(), "4bf92f3577b34da6a3ce929d0e0e4736")
+	}
+
+	server := spans["GET /snippet/view/{id}"]
+	assert.Equal(t, server.Parent().SpanID().String(), "00f067aa0ba902b7")
+	assert.Equal(t, spans["recoverPanic"].Parent().SpanID(), server.SpanContext().SpanID())
+	assert.Equal(t, spans["render"].Parent().SpanID(), spans["handler"].SpanContext().SpanID())
+}
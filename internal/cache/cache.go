@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Store is a key-value cache where every entry has a time to live. Values are opaque bytes so that a Store
+// can be implemented on top of an external cache like Redis or Memcached, which lets several instances of
+// the application share the cache (and see each other's invalidations).
+type Store interface {
+	// Get returns the value for key, and false if there isn't one or it has expired.
+	Get(ctx context.Context, key string) (value []byte, found bool, err error)
+	// Set stores value for key, replacing any existing value. The entry expires after ttl.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes the entry for key, if there is one.
+	Delete(ctx context.Context, key string) error
+}
+
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// MemoryStore is an in-memory Store which holds up to a fixed number of entries. When it is full, the least
+// recently used entry is evicted to make room for a new one.
+type MemoryStore struct {
+	mu       sync.Mutex
+	capacity int
+	// order holds the entries from the most to the least recently used, and items indexes them by key.
+	order *list.List
+	items map[string]*list.Element
+	// now is used in place of time.Now, so that tests can control the clock.
+	now func() time.Time
+}
+
+// NewMemoryStore returns a new, empty MemoryStore which holds up to capacity entries.
+func NewMemoryStore(capacity int) *MemoryStore {
+	return &MemoryStore{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+		now:      time.Now,
+	}
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	e := el.Value.(*entry)
+	if !s.now().Before(e.expires) {
+		s.remove(el)
+		return nil, false, nil
+	}
+
+	s.order.MoveToFront(el)
+	return e.value, true, nil
+}
+
+// Set implements Store.
+func (s *MemoryStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expires := s.now().Add(ttl)
+
+	if el, ok := s.items[key]; ok {
+		e := el.Value.(*entry)
+		e.value = value
+		e.expires = expires
+		s.order.MoveToFront(el)
+		return nil
+	}
+
+	s.items[key] = s.order.PushFront(&entry{key: key, value: value, expires: expires})
+
+	for s.order.Len() > s.capacity {
+		s.remove(s.order.Back())
+	}
+
+	return nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[key]; ok {
+		s.remove(el)
+	}
+
+	return nil
+}
+
+// Len returns the number of entries in the store, including any which have expired but haven't been
+// removed yet.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.order.Len()
+}
+
+func (s *MemoryStore) remove(el *list.Element) {
+	s.order.Remove(el)
+	delete(s.items, el.Value.(*entry).key)
+}
@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 12, 12, 10, 15, 0, 0, time.UTC)
+
+	s := NewMemoryStore(2)
+	s.now = func() time.Time { return now }
+
+	_, found, err := s.Get(ctx, "a")
+	assert.NilError(t, err)
+	assert.Equal(t, found, false)
+
+	assert.NilError(t, s.Set(ctx, "a", []byte("1"), time.Minute))
+	assert.NilError(t, s.Set(ctx, "b", []byte("2"), time.Minute))
+
+	value, found, err := s.Get(ctx, "a")
+	assert.NilError(t, err)
+	assert.Equal(t, found, true)
+	assert.Equal(t, string(value), "1")
+
+	// The store is full, so adding c evicts the least recently used entry, which is b because we've just
+	// read a.
+	assert.NilError(t, s.Set(ctx, "c", []byte("3"), time.Minute))
+	assert.Equal(t, s.Len(), 2)
+
+	_, found, _ = s.Get(ctx, "b")
+	assert.Equal(t, found, false)
+	_, found, _ = s.Get(ctx, "a")
+	assert.Equal(t, found, true)
+
+	// Replacing an entry updates its value and time to live.
+	assert.NilError(t, s.Set(ctx, "a", []byte("4"), 2*time.Minute))
+	value, _, _ = s.Get(ctx, "a")
+	assert.Equal(t, string(value), "4")
+
+	// Entries expire after their time to live.
+	now = now.Add(time.Minute)
+	_, found, _ = s.Get(ctx, "c")
+	assert.Equal(t, found, false)
+	_, found, _ = s.Get(ctx, "a")
+	assert.Equal(t, found, true)
+
+	assert.NilError(t, s.Delete(ctx, "a"))
+	_, found, _ = s.Get(ctx, "a")
+	assert.Equal(t, found, false)
+	assert.Equal(t, s.Len(), 0)
+}
@@ -0,0 +1,315 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CommentStatus is the moderation state of a comment. Published comments are shown to everyone, pending
+// comments only to their author and moderators until a moderator approves them, and hidden comments only
+// to moderators. Deleted comments have had their body removed, and are only kept so that the replies to
+// them stay in place.
+type CommentStatus string
+
+const (
+	CommentPublished CommentStatus = "published"
+	CommentPending   CommentStatus = "pending"
+	CommentHidden    CommentStatus = "hidden"
+	CommentDeleted   CommentStatus = "deleted"
+)
+
+// MaxCommentLength is the longest a comment can be, in characters, and MaxCommentDepth is the deepest that
+// replies are indented. Replies can be nested further, but they're shown at this depth.
+const (
+	MaxCommentLength = 5000
+	MaxCommentDepth  = 4
+)
+
+// LineRange is the lines of a file which a comment is about, from Start to End inclusive. The zero
+// LineRange means the comment is about the whole snippet.
+type LineRange struct {
+	File  string
+	Start int
+	End   int
+}
+
+// String returns the line range in the form used in URL fragments, like L10 or L10-L20.
+func (lr LineRange) String() string {
+	if lr.Start == lr.End {
+		return fmt.Sprintf("L%d", lr.Start)
+	}
+	return fmt.Sprintf("L%d-L%d", lr.Start, lr.End)
+}
+
+// ParseLineRange parses a range of lines written as 10, 10-20, L10 or L10-L20, returning false if it isn't
+// valid. The File of the returned LineRange is empty.
+func ParseLineRange(s string) (LineRange, bool) {
+	s = strings.TrimSpace(s)
+	first, last, isRange := strings.Cut(s, "-")
+
+	start, ok := parseLineNumber(first)
+	if !ok {
+		return LineRange{}, false
+	}
+	end := start
+	if isRange {
+		end, ok = parseLineNumber(last)
+		if !ok || end < start {
+			return LineRange{}, false
+		}
+	}
+
+	return LineRange{Start: start, End: end}, true
+}
+
+// parseLineNumber parses a line number, with or without an L in front of it.
+func parseLineNumber(s string) (int, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "L")
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
+
+// Comment holds a comment on a snippet. ParentID is the comment it replies to, or 0 for a top level
+// comment. Top level comments can be about a range of lines in one of the snippet's files, and replies are
+// shown alongside them. UserName is the name of the author, and Depth is set by ThreadComments.
+type Comment struct {
+	ID        int
+	SnippetID int
+	UserID    int
+	UserName  string
+	ParentID  int
+	Lines     LineRange
+	Body      string
+	Status    CommentStatus
+	Created   time.Time
+	Updated   time.Time
+	Depth     int
+}
+
+// Edited returns true if the comment has been changed since it was posted.
+func (c Comment) Edited() bool {
+	return c.Updated.After(c.Created)
+}
+
+type CommentModelInterface interface {
+	Insert(ctx context.Context, snippetID, userID, parentID int, lines LineRange, body string, status CommentStatus) (int, error)
+	Get(ctx context.Context, id int) (Comment, error)
+	ForSnippet(ctx context.Context, snippetID int) ([]Comment, error)
+	Update(ctx context.Context, id int, body string, status CommentStatus) error
+	SetStatus(ctx context.Context, id int, status CommentStatus) error
+	Delete(ctx context.Context, id int) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// CommentModel type which wraps a sql.DB connection pool
+type CommentModel struct {
+	DB *sql.DB
+	// QueryTimeout limits how long each method can spend waiting on the database. Zero means no limit.
+	QueryTimeout time.Duration
+}
+
+// Insert adds a comment to a snippet, returning its ID. Use the zero LineRange for comments which aren't
+// about particular lines.
+func (m *CommentModel) Insert(ctx context.Context, snippetID, userID, parentID int, lines LineRange, body string, status CommentStatus) (int, error) {
+	ctx, done := startQuery(ctx, "CommentModel.Insert", m.QueryTimeout)
+	defer done()
+
+	stmt := `
+		INSERT INTO comments (snippet_id, user_id, parent_id, file, line_start, line_end, body, status, created, updated)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP())
+	`
+
+	result, err := m.DB.ExecContext(ctx, stmt, snippetID, userID, parentID, lines.File, lines.Start, lines.End, body, status)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// Get returns a specific comment, as long as its snippet hasn't expired.
+func (m *CommentModel) Get(ctx context.Context, id int) (Comment, error) {
+	ctx, done := startQuery(ctx, "CommentModel.Get", m.QueryTimeout)
+	defer done()
+
+	stmt := `
+		SELECT c.id, c.snippet_id, c.user_id, u.name, c.parent_id, c.file, c.line_start, c.line_end, c.body, c.status, c.created, c.updated
+		FROM comments c
+		JOIN users u ON u.id = c.user_id
+		JOIN snippets s ON s.id = c.snippet_id
+		WHERE c.id = ? AND s.expires > UTC_TIMESTAMP()
+	`
+
+	var c Comment
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&c.ID, &c.SnippetID, &c.UserID, &c.UserName, &c.ParentID, &c.Lines.File, &c.Lines.Start, &c.Lines.End, &c.Body, &c.Status, &c.Created, &c.Updated)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Comment{}, ErrNoRecord
+		}
+		return Comment{}, err
+	}
+
+	return c, nil
+}
+
+// ForSnippet returns all the comments on a snippet, whatever their status, in the order they were posted.
+// Use ThreadComments to put them in order for display.
+func (m *CommentModel) ForSnippet(ctx context.Context, snippetID int) ([]Comment, error) {
+	ctx, done := startQuery(ctx, "CommentModel.ForSnippet", m.QueryTimeout)
+	defer done()
+
+	stmt := `
+		SELECT c.id, c.snippet_id, c.user_id, u.name, c.parent_id, c.file, c.line_start, c.line_end, c.body, c.status, c.created, c.updated
+		FROM comments c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.snippet_id = ?
+		ORDER BY c.id
+	`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		var c Comment
+		err = rows.Scan(&c.ID, &c.SnippetID, &c.UserID, &c.UserName, &c.ParentID, &c.Lines.File, &c.Lines.Start, &c.Lines.End, &c.Body, &c.Status, &c.Created, &c.Updated)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+// Update changes the body of a comment after it has been edited, along with its status, as the edited
+// comment has to go through moderation again.
+func (m *CommentModel) Update(ctx context.Context, id int, body string, status CommentStatus) error {
+	ctx, done := startQuery(ctx, "CommentModel.Update", m.QueryTimeout)
+	defer done()
+
+	stmt := `UPDATE comments SET body = ?, status = ?, updated = UTC_TIMESTAMP() WHERE id = ? AND status <> ?`
+
+	return execOne(ctx, m.DB, stmt, body, status, id, CommentDeleted)
+}
+
+// SetStatus changes the moderation status of a comment.
+func (m *CommentModel) SetStatus(ctx context.Context, id int, status CommentStatus) error {
+	ctx, done := startQuery(ctx, "CommentModel.SetStatus", m.QueryTimeout)
+	defer done()
+
+	stmt := `UPDATE comments SET status = ?, updated = UTC_TIMESTAMP() WHERE id = ? AND status <> ?`
+
+	return execOne(ctx, m.DB, stmt, status, id, CommentDeleted)
+}
+
+// Delete removes the body of a comment and marks it as deleted. The row is kept so that any replies to the
+// comment still have somewhere to hang from.
+func (m *CommentModel) Delete(ctx context.Context, id int) error {
+	ctx, done := startQuery(ctx, "CommentModel.Delete", m.QueryTimeout)
+	defer done()
+
+	stmt := `UPDATE comments SET body = '', status = ?, updated = UTC_TIMESTAMP() WHERE id = ? AND status <> ?`
+
+	return execOne(ctx, m.DB, stmt, CommentDeleted, id, CommentDeleted)
+}
+
+// DeleteExpired removes all the comments on snippets which have expired or been deleted, and returns how
+// many were removed.
+func (m *CommentModel) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, done := startQuery(ctx, "CommentModel.DeleteExpired", m.QueryTimeout)
+	defer done()
+
+	stmt := `
+		DELETE c FROM comments c
+		LEFT JOIN snippets s ON s.id = c.snippet_id
+		WHERE s.id IS NULL OR s.expires <= UTC_TIMESTAMP()
+	`
+
+	result, err := m.DB.ExecContext(ctx, stmt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// execOne executes a statement which should change exactly one row, returning ErrNoRecord if it didn't.
+func execOne(ctx context.Context, db *sql.DB, stmt string, args ...any) error {
+	result, err := db.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// ThreadComments puts comments in the order they're shown in, with each reply after the comment it replies
+// to, and sets their Depth. Only the comments that visible returns true for are shown. The others, and
+// deleted comments, are left out unless they have replies which are shown, in which case they're returned
+// as deleted comments with their bodies removed, so that the replies keep their place.
+func ThreadComments(comments []Comment, visible func(Comment) bool) []Comment {
+	replies := map[int][]Comment{}
+	ids := map[int]bool{}
+	for _, c := range comments {
+		ids[c.ID] = true
+	}
+	for _, c := range comments {
+		// Replies to comments which aren't in the list are shown as top level comments.
+		parentID := c.ParentID
+		if !ids[parentID] {
+			parentID = 0
+		}
+		replies[parentID] = append(replies[parentID], c)
+	}
+
+	var thread func(parentID, depth int) []Comment
+	thread = func(parentID, depth int) []Comment {
+		var threaded []Comment
+		for _, c := range replies[parentID] {
+			c.Depth = min(depth, MaxCommentDepth)
+			below := thread(c.ID, depth+1)
+
+			if c.Status == CommentDeleted || !visible(c) {
+				if len(below) == 0 {
+					continue
+				}
+				c.Status = CommentDeleted
+				c.Body = ""
+			}
+
+			threaded = append(threaded, c)
+			threaded = append(threaded, below...)
+		}
+		return threaded
+	}
+
+	return thread(0, 0)
+}
@@ -0,0 +1,94 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+func TestThreadComments(t *testing.T) {
+	// Comments 1-7 form a chain of replies, deeper than MaxCommentDepth. Comment 8 is pending, 9 is a deleted
+	// comment with a reply, 10 is the reply, 11 is a deleted comment without replies and 12 replies to a
+	// comment which isn't in the list.
+	comments := []Comment{
+		{ID: 1, Status: CommentPublished},
+		{ID: 2, ParentID: 1, Status: CommentPublished},
+		{ID: 3, ParentID: 2, Status: CommentPublished},
+		{ID: 4, ParentID: 3, Status: CommentPublished},
+		{ID: 5, ParentID: 4, Status: CommentPublished},
+		{ID: 6, ParentID: 5, Status: CommentPublished},
+		{ID: 7, ParentID: 1, Status: CommentPublished},
+		{ID: 8, ParentID: 1, Status: CommentPending, Body: "pending"},
+		{ID: 9, Status: CommentDeleted},
+		{ID: 10, ParentID: 9, Status: CommentPublished},
+		{ID: 11, Status: CommentDeleted},
+		{ID: 12, ParentID: 99, Status: CommentPublished},
+		{ID: 13, Status: CommentPending, Body: "pending"},
+		{ID: 14, ParentID: 13, Status: CommentPublished},
+	}
+
+	published := func(c Comment) bool { return c.Status == CommentPublished }
+
+	// format lists the comments as id:depth, with a * for deleted comments.
+	format := func(comments []Comment) string {
+		var s []string
+		for _, c := range comments {
+			deleted := ""
+			if c.Status == CommentDeleted {
+				deleted = "*"
+			}
+			s = append(s, fmt.Sprintf("%d:%d%s", c.ID, c.Depth, deleted))
+		}
+		return strings.Join(s, " ")
+	}
+
+	got := ThreadComments(comments, published)
+	assert.Equal(t, format(got), "1:0 2:1 3:2 4:3 5:4 6:4 7:1 9:0* 10:1 12:0 13:0* 14:1")
+
+	// Hidden comments with visible replies don't give away what they said.
+	for _, c := range got {
+		if c.ID == 13 {
+			assert.Equal(t, c.Body, "")
+		}
+	}
+
+	all := func(c Comment) bool { return true }
+	got = ThreadComments(comments, all)
+	assert.Equal(t, format(got), "1:0 2:1 3:2 4:3 5:4 6:4 7:1 8:1 9:0* 10:1 12:0 13:0 14:1")
+
+	assert.Equal(t, len(ThreadComments(nil, all)), 0)
+}
+
+func TestParseLineRange(t *testing.T) {
+	tests := []struct {
+		input  string
+		want   LineRange
+		wantOK bool
+	}{
+		{input: "10", want: LineRange{Start: 10, End: 10}, wantOK: true},
+		{input: "10-20", want: LineRange{Start: 10, End: 20}, wantOK: true},
+		{input: " L10 - L20 ", want: LineRange{Start: 10, End: 20}, wantOK: true},
+		{input: "L7", want: LineRange{Start: 7, End: 7}, wantOK: true},
+		{input: "", wantOK: false},
+		{input: "0", wantOK: false},
+		{input: "20-10", wantOK: false},
+		{input: "10-", wantOK: false},
+		{input: "ten", wantOK: false},
+		{input: "1-2-3", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, ok := ParseLineRange(tt.input)
+			assert.Equal(t, ok, tt.wantOK)
+			assert.Equal(t, got, tt.want)
+		})
+	}
+}
+
+func TestLineRangeString(t *testing.T) {
+	assert.Equal(t, LineRange{Start: 3, End: 3}.String(), "L3")
+	assert.Equal(t, LineRange{Start: 10, End: 20}.String(), "L10-L20")
+}
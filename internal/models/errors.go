@@ -7,4 +7,9 @@ var (
 	ErrNoRecord = errors.New("models: no matching record found")
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 	ErrDuplicateEmail = errors.New("models: duplicate email")
+	ErrRememberTokenReused = errors.New("models: remember token reused")
+	ErrAccountDisabled = errors.New("models: account disabled")
+	ErrExternalUser = errors.New("models: user is authenticated externally")
+	ErrLocalAccount = errors.New("models: email address belongs to a local account")
+	ErrIdentityInUse = errors.New("models: identity is linked to another user")
 )
\ No newline at end of file
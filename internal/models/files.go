@@ -0,0 +1,135 @@
+package models
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// MaxFiles is the most files a snippet can have, MaxFilenameLength is the longest a filename can be, and
+// MaxSnippetSize is the most content, in bytes, that all the files in a snippet can hold between them.
+const (
+	MaxFiles          = 10
+	MaxFilenameLength = 100
+	MaxSnippetSize    = 512 << 10
+)
+
+// DefaultFilename is the name of the single file in snippets which were created before snippets could have
+// more than one file.
+const DefaultFilename = "snippet.txt"
+
+// FilenameRX matches the filenames which snippet files can have: letters, digits, dots, underscores and
+// hyphens, not starting with a dot. Filenames appear in URLs and archives, so they can't contain slashes,
+// and "." and ".." aren't allowed.
+var FilenameRX = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// SnippetFile holds one of the files in a snippet. Files are shown in order of Position, starting at 0.
+type SnippetFile struct {
+	Name     string
+	Language string
+	Content  string
+	Position int
+}
+
+// Line is a numbered line of a file. Lines are numbered from 1.
+type Line struct {
+	Number int
+	Text   string
+}
+
+// Lines splits the file into lines. A newline at the end of the file doesn't start another line, but an
+// empty file has one empty line.
+func (f SnippetFile) Lines() []Line {
+	content := strings.ReplaceAll(f.Content, "\r\n", "\n")
+	content = strings.TrimSuffix(content, "\n")
+
+	var lines []Line
+	for i, text := range strings.Split(content, "\n") {
+		lines = append(lines, Line{Number: i + 1, Text: text})
+	}
+	return lines
+}
+
+// Language is one of the languages which a snippet file can be written in.
+type Language struct {
+	ID   string
+	Name string
+}
+
+// Languages lists the languages which users can choose from for their snippet files.
+var Languages = []Language{
+	{"text", "Plain text"},
+	{"c", "C"},
+	{"cpp", "C++"},
+	{"css", "CSS"},
+	{"dockerfile", "Dockerfile"},
+	{"go", "Go"},
+	{"html", "HTML"},
+	{"ini", "INI"},
+	{"java", "Java"},
+	{"javascript", "JavaScript"},
+	{"json", "JSON"},
+	{"makefile", "Makefile"},
+	{"markdown", "Markdown"},
+	{"python", "Python"},
+	{"ruby", "Ruby"},
+	{"rust", "Rust"},
+	{"shell", "Shell"},
+	{"sql", "SQL"},
+	{"toml", "TOML"},
+	{"typescript", "TypeScript"},
+	{"xml", "XML"},
+	{"yaml", "YAML"},
+}
+
+// LanguageIDs returns the IDs of all the Languages, for validating the language of a file.
+func LanguageIDs() []string {
+	ids := make([]string, len(Languages))
+	for i, l := range Languages {
+		ids[i] = l.ID
+	}
+	return ids
+}
+
+// LanguageName returns the display name of the language with the given ID. Files in snippets which were
+// created before snippets could have more than one file have no language, and are shown as plain text.
+func LanguageName(id string) string {
+	for _, l := range Languages {
+		if l.ID == id {
+			return l.Name
+		}
+	}
+	return "Plain text"
+}
+
+// languagesByFilename and languagesByExtension map filenames and extensions to languages, for files where
+// the user doesn't choose one.
+var (
+	languagesByFilename = map[string]string{
+		"dockerfile": "dockerfile",
+		"makefile":   "makefile",
+	}
+	languagesByExtension = map[string]string{
+		".c": "c", ".h": "c", ".cc": "cpp", ".cpp": "cpp", ".hpp": "cpp", ".css": "css",
+		".dockerfile": "dockerfile", ".go": "go", ".html": "html", ".htm": "html", ".ini": "ini",
+		".java": "java", ".js": "javascript", ".mjs": "javascript", ".json": "json", ".mk": "makefile",
+		".md": "markdown", ".py": "python", ".rb": "ruby", ".rs": "rust", ".sh": "shell", ".bash": "shell",
+		".sql": "sql", ".toml": "toml", ".ts": "typescript", ".xml": "xml", ".yaml": "yaml", ".yml": "yaml",
+	}
+)
+
+// DetectLanguage guesses the language of a file from its name, like "dockerfile" for "Dockerfile" and
+// "yaml" for "compose.yml". It returns "text" (plain text) if there's no match.
+func DetectLanguage(filename string) string {
+	name := strings.ToLower(filename)
+	if language, ok := languagesByFilename[name]; ok {
+		return language
+	}
+	if strings.HasPrefix(name, "dockerfile.") {
+		return "dockerfile"
+	}
+	if language, ok := languagesByExtension[path.Ext(name)]; ok {
+		return language
+	}
+	return "text"
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{filename: "Dockerfile", want: "dockerfile"},
+		{filename: "Dockerfile.dev", want: "dockerfile"},
+		{filename: "compose.yml", want: "yaml"},
+		{filename: "run.sh", want: "shell"},
+		{filename: "main.GO", want: "go"},
+		{filename: "Makefile", want: "makefile"},
+		{filename: "notes", want: "text"},
+		{filename: "data.unknown", want: "text"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			assert.Equal(t, DetectLanguage(tt.filename), tt.want)
+		})
+	}
+}
+
+func TestSnippetFileLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{name: "Empty", content: "", want: []string{""}},
+		{name: "One line", content: "one", want: []string{"one"}},
+		{name: "Trailing newline", content: "one\ntwo\n", want: []string{"one", "two"}},
+		{name: "Blank lines", content: "one\n\nthree\n\n", want: []string{"one", "", "three", ""}},
+		{name: "CRLF", content: "one\r\ntwo", want: []string{"one", "two"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for i, line := range (SnippetFile{Content: tt.content}).Lines() {
+				assert.Equal(t, line.Number, i+1)
+				got = append(got, line.Text)
+			}
+			assert.Equal(t, slices.Equal(got, tt.want), true)
+		})
+	}
+}
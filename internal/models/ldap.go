@@ -0,0 +1,240 @@
+package models
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// errLDAPUserNotFound is returned internally when the directory has no (or more than one) entry matching the
+// user filter.
+var errLDAPUserNotFound = errors.New("models: ldap user not found")
+
+// LDAPConfig holds the settings for authenticating users against an LDAP or Active Directory server.
+type LDAPConfig struct {
+	// URL of the directory server, for example ldap://ldap.example.com:389 or ldaps://ldap.example.com:636.
+	URL string
+	// StartTLS upgrades a plain ldap:// connection to TLS before binding.
+	StartTLS bool
+	// TLSConfig is used for ldaps:// and StartTLS connections.
+	TLSConfig *tls.Config
+	// BindDN and BindPassword are the credentials of the service account used to search for users. If BindDN
+	// is empty the searches are made anonymously.
+	BindDN       string
+	BindPassword string
+	// BaseDN is the base of the subtree to search for users and groups.
+	BaseDN string
+	// UserFilter finds the entry for a user. Any %s in it is replaced with their (escaped) email address.
+	UserFilter string
+	// GroupFilter, if set, must match at least one entry under BaseDN for the user to be allowed to log in.
+	// Any %s in it is replaced with the (escaped) DN of the user.
+	GroupFilter string
+	// NameAttribute and EmailAttribute are the attributes copied to the local user record on each login.
+	NameAttribute  string
+	EmailAttribute string
+	// LocalFallback allows local accounts to log in when the user isn't in the directory, or the directory
+	// can't be reached. This is intended for break-glass admin accounts.
+	LocalFallback bool
+	// Timeout applies to both connecting and each operation on the directory.
+	Timeout time.Duration
+}
+
+// LDAPUserModel authenticates users against an LDAP directory. The first time a directory user logs in a local
+// user record is provisioned for them, and their name and email are kept in sync on every subsequent login.
+// Every other method is handled by the embedded local user model.
+type LDAPUserModel struct {
+	UserModelInterface
+	Config LDAPConfig
+}
+
+// Authenticate checks the email and password against the directory, and returns the ID of the linked local
+// user. If the user isn't in the directory (or it is unreachable) and LocalFallback is enabled, we try the
+// local user model instead.
+func (m *LDAPUserModel) Authenticate(ctx context.Context, email, password string) (int, error) {
+	ctx, span := startSpan(ctx, "LDAPUserModel.Authenticate")
+	defer span.End()
+
+	// An LDAP simple bind with an empty password is an "unauthenticated bind", which most servers
+	// report as successful. So we must never pass an empty password through to the directory.
+	if password == "" {
+		return 0, ErrInvalidCredentials
+	}
+
+	id, err := m.authenticateLDAP(ctx, email, password)
+	if err != nil {
+		if m.Config.LocalFallback && (errors.Is(err, errLDAPUserNotFound) || ldap.IsErrorWithCode(err, ldap.ErrorNetwork)) {
+			return m.UserModelInterface.Authenticate(ctx, email, password)
+		}
+		if errors.Is(err, errLDAPUserNotFound) {
+			return 0, ErrInvalidCredentials
+		}
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (m *LDAPUserModel) authenticateLDAP(ctx context.Context, email, password string) (int, error) {
+	conn, err := m.dial()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	err = m.bindServiceAccount(conn)
+	if err != nil {
+		return 0, err
+	}
+
+	entry, err := m.findUser(conn, email)
+	if err != nil {
+		return 0, err
+	}
+
+	// Check the user's password by binding as them.
+	err = conn.Bind(entry.DN, password)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return 0, ErrInvalidCredentials
+		}
+		return 0, err
+	}
+
+	if m.Config.GroupFilter != "" {
+		// Switch back to the service account, as users often can't read group memberships themselves.
+		err = m.bindServiceAccount(conn)
+		if err != nil {
+			return 0, err
+		}
+
+		ok, err := m.inGroup(conn, entry.DN)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, ErrInvalidCredentials
+		}
+	}
+
+	name := entry.GetAttributeValue(m.Config.NameAttribute)
+	mail := entry.GetAttributeValue(m.Config.EmailAttribute)
+	if mail == "" {
+		mail = email
+	}
+	if name == "" {
+		name = mail
+	}
+
+	// We use the DN to identify the directory user. If users are renamed in the directory they will be
+	// linked back to the same local user by their email address.
+	return m.provisionUser(ctx, entry.DN, name, mail, password)
+}
+
+// provisionUser returns the ID of the local user for a directory user who has just logged in, creating them
+// if necessary. Like other external identities, directory users aren't linked to local accounts with a
+// password just because their email addresses match, as anyone could have signed up with that address. But
+// if the password the user logged in with is also the local account's password, they have shown that they
+// own both, so we link them.
+func (m *LDAPUserModel) provisionUser(ctx context.Context, dn, name, mail, password string) (int, error) {
+	id, err := m.UpsertExternal(ctx, "ldap", dn, name, mail)
+	if !errors.Is(err, ErrLocalAccount) {
+		return id, err
+	}
+
+	id, err = m.UserModelInterface.Authenticate(ctx, mail, password)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCredentials) {
+			return 0, ErrLocalAccount
+		}
+		return 0, err
+	}
+
+	err = m.LinkExternal(ctx, "ldap", dn, id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (m *LDAPUserModel) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(m.Config.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: m.Config.Timeout}),
+		ldap.DialWithTLSConfig(m.Config.TLSConfig),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Config.Timeout > 0 {
+		conn.SetTimeout(m.Config.Timeout)
+	}
+
+	if m.Config.StartTLS {
+		err = conn.StartTLS(m.Config.TLSConfig)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func (m *LDAPUserModel) bindServiceAccount(conn *ldap.Conn) error {
+	if m.Config.BindDN == "" {
+		return conn.UnauthenticatedBind("")
+	}
+
+	return conn.Bind(m.Config.BindDN, m.Config.BindPassword)
+}
+
+func (m *LDAPUserModel) findUser(conn *ldap.Conn, email string) (*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(
+		m.Config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(m.Config.Timeout.Seconds()), false,
+		strings.ReplaceAll(m.Config.UserFilter, "%s", ldap.EscapeFilter(email)),
+		[]string{"dn", m.Config.NameAttribute, m.Config.EmailAttribute},
+		nil,
+	)
+
+	result, err := conn.Search(req)
+	if err != nil {
+		// A size limit error means more than one entry matched, which we treat the same as none.
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return nil, errLDAPUserNotFound
+		}
+		return nil, err
+	}
+
+	if len(result.Entries) != 1 {
+		return nil, errLDAPUserNotFound
+	}
+
+	return result.Entries[0], nil
+}
+
+func (m *LDAPUserModel) inGroup(conn *ldap.Conn, userDN string) (bool, error) {
+	req := ldap.NewSearchRequest(
+		m.Config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, int(m.Config.Timeout.Seconds()), false,
+		strings.ReplaceAll(m.Config.GroupFilter, "%s", ldap.EscapeFilter(userDN)),
+		[]string{"dn"},
+		nil,
+	)
+
+	result, err := conn.Search(req)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	return len(result.Entries) > 0, nil
+}
@@ -0,0 +1,215 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+// localUserModel is a stand-in for the local user model wrapped by LDAPUserModel. It knows about a single
+// break-glass account, which directory users can only be linked to explicitly, and provisions every other
+// external user as user ID 2.
+type localUserModel struct {
+	UserModelInterface
+	linked map[string]int
+}
+
+func (m *localUserModel) Authenticate(ctx context.Context, email, password string) (int, error) {
+	if email == "admin@example.com" && password == "pa$$word" {
+		return 1, nil
+	}
+
+	return 0, ErrInvalidCredentials
+}
+
+func (m *localUserModel) UpsertExternal(ctx context.Context, provider, subject, name, email string) (int, error) {
+	if email == "admin@example.com" && m.linked[subject] == 0 {
+		return 0, ErrLocalAccount
+	}
+
+	return 2, nil
+}
+
+func (m *localUserModel) LinkExternal(ctx context.Context, provider, subject string, userID int) error {
+	if m.linked == nil {
+		m.linked = map[string]int{}
+	}
+	m.linked[subject] = userID
+	return nil
+}
+
+func TestLDAPUserModelFallback(t *testing.T) {
+	tests := []struct {
+		name          string
+		localFallback bool
+		email         string
+		password      string
+		wantID        int
+		wantErr       bool
+	}{
+		{
+			name:          "Fallback to local account",
+			localFallback: true,
+			email:         "admin@example.com",
+			password:      "pa$$word",
+			wantID:        1,
+		},
+		{
+			name:          "Fallback with wrong password",
+			localFallback: true,
+			email:         "admin@example.com",
+			password:      "wrong",
+			wantErr:       true,
+		},
+		{
+			name:          "Fallback disabled",
+			localFallback: false,
+			email:         "admin@example.com",
+			password:      "pa$$word",
+			wantErr:       true,
+		},
+		{
+			name:          "Empty password",
+			localFallback: true,
+			email:         "admin@example.com",
+			password:      "",
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Point the model at a port with nothing listening on it, to simulate the directory being down.
+			m := LDAPUserModel{
+				UserModelInterface: &localUserModel{},
+				Config: LDAPConfig{
+					URL:           "ldap://127.0.0.1:1",
+					LocalFallback: tt.localFallback,
+					Timeout:       time.Second,
+				},
+			}
+
+			id, err := m.Authenticate(context.Background(), tt.email, tt.password)
+			assert.Equal(t, id, tt.wantID)
+			assert.Equal(t, err != nil, tt.wantErr)
+		})
+	}
+}
+
+func TestLDAPUserModelProvisionUser(t *testing.T) {
+	local := &localUserModel{}
+	m := LDAPUserModel{UserModelInterface: local}
+	ctx := context.Background()
+
+	id, err := m.provisionUser(ctx, "uid=carol", "Carol", "carol@example.com", "directory-password")
+	assert.NilError(t, err)
+	assert.Equal(t, id, 2)
+
+	// Somebody signed up locally with the directory user's email address before they first logged in. The
+	// directory user doesn't know that account's password, so they mustn't be given it.
+	id, err = m.provisionUser(ctx, "uid=admin", "Admin", "admin@example.com", "directory-password")
+	assert.Equal(t, err, ErrLocalAccount)
+	assert.Equal(t, id, 0)
+	assert.Equal(t, len(local.linked), 0)
+
+	// If the directory user does know the local password, the accounts are linked.
+	id, err = m.provisionUser(ctx, "uid=admin", "Admin", "admin@example.com", "pa$$word")
+	assert.NilError(t, err)
+	assert.Equal(t, id, 1)
+	assert.Equal(t, local.linked["uid=admin"], 1)
+}
+
+func TestLDAPUserModelLocalAccountTakeover(t *testing.T) {
+	db := newTestDB(t)
+	users := &UserModel{DB: db}
+	m := LDAPUserModel{UserModelInterface: users}
+	ctx := context.Background()
+
+	// An attacker signs up with the address of a directory user who hasn't logged in yet.
+	err := users.Insert(ctx, "Mallory", "victim@example.com", "attacker-password")
+	assert.NilError(t, err)
+
+	_, err = m.provisionUser(ctx, "uid=victim,ou=people,dc=example,dc=com", "Victim", "victim@example.com", "directory-password")
+	assert.Equal(t, errors.Is(err, ErrLocalAccount), true)
+
+	// The directory identity wasn't linked to the attacker's account, so it can't be used to get at anything
+	// the victim does through it.
+	var n int
+	err = db.QueryRow(`SELECT COUNT(*) FROM user_identities`).Scan(&n)
+	assert.NilError(t, err)
+	assert.Equal(t, n, 0)
+}
+
+func TestLDAPUserModelAuthenticate(t *testing.T) {
+	m := LDAPUserModel{
+		UserModelInterface: &localUserModel{},
+		Config: LDAPConfig{
+			URL:            "ldap://localhost:3389",
+			BindDN:         "cn=admin,dc=example,dc=com",
+			BindPassword:   "admin",
+			BaseDN:         "dc=example,dc=com",
+			UserFilter:     "(&(objectClass=person)(mail=%s))",
+			GroupFilter:    "(&(objectClass=groupOfNames)(cn=snippetbox)(member=%s))",
+			NameAttribute:  "cn",
+			EmailAttribute: "mail",
+			Timeout:        time.Second,
+		},
+	}
+
+	// This test needs the local LDAP server described in testdata/ldap.ldif.
+	conn, err := m.dial()
+	if err != nil {
+		t.Skipf("local LDAP server not available: %s", err)
+	}
+	conn.Close()
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		wantID   int
+		wantErr  error
+	}{
+		{
+			name:     "Valid credentials",
+			email:    "alice@example.com",
+			password: "pa$$word",
+			wantID:   2,
+		},
+		{
+			name:     "Wrong password",
+			email:    "alice@example.com",
+			password: "wrong",
+			wantErr:  ErrInvalidCredentials,
+		},
+		{
+			name:     "Not in group",
+			email:    "bob@example.com",
+			password: "pa$$word",
+			wantErr:  ErrInvalidCredentials,
+		},
+		{
+			name:     "Unknown user",
+			email:    "carol@example.com",
+			password: "pa$$word",
+			wantErr:  ErrInvalidCredentials,
+		},
+		{
+			name:     "Filter injection",
+			email:    "*",
+			password: "pa$$word",
+			wantErr:  ErrInvalidCredentials,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := m.Authenticate(context.Background(), tt.email, tt.password)
+			assert.Equal(t, id, tt.wantID)
+			assert.Equal(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com/vishal-rfx/snippetbox/internal/models"
+)
+
+// mockCommentTime is when all the mock comments were posted. None of them have been edited.
+var mockCommentTime = time.Now()
+
+// mockComments are the comments on the mock snippet: a comment by Alice, a reply to it by the admin, a
+// comment by the admin which is waiting for moderation, and a comment by Alice on the first line of
+// pond.txt.
+var mockComments = []models.Comment{
+	{
+		ID:        1,
+		SnippetID: 1,
+		UserID:    1,
+		UserName:  "Alice Jones",
+		Body:      "What a **lovely** pond",
+		Status:    models.CommentPublished,
+		Created:   mockCommentTime,
+		Updated:   mockCommentTime,
+	},
+	{
+		ID:        2,
+		SnippetID: 1,
+		UserID:    3,
+		UserName:  "Admin",
+		ParentID:  1,
+		Body:      "Mind the frog",
+		Status:    models.CommentPublished,
+		Created:   mockCommentTime,
+		Updated:   mockCommentTime,
+	},
+	{
+		ID:        3,
+		SnippetID: 1,
+		UserID:    3,
+		UserName:  "Admin",
+		Body:      "Buy cheap ponds at example.com",
+		Status:    models.CommentPending,
+		Created:   mockCommentTime,
+		Updated:   mockCommentTime,
+	},
+	{
+		ID:        4,
+		SnippetID: 1,
+		UserID:    1,
+		UserName:  "Alice Jones",
+		Lines:     models.LineRange{File: "pond.txt", Start: 1, End: 1},
+		Body:      "Such a quiet first line",
+		Status:    models.CommentPublished,
+		Created:   mockCommentTime,
+		Updated:   mockCommentTime,
+	},
+}
+
+type CommentModel struct{}
+
+func (m *CommentModel) Insert(ctx context.Context, snippetID, userID, parentID int, lines models.LineRange, body string, status models.CommentStatus) (int, error) {
+	return 5, nil
+}
+
+func (m *CommentModel) Get(ctx context.Context, id int) (models.Comment, error) {
+	for _, c := range mockComments {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return models.Comment{}, models.ErrNoRecord
+}
+
+func (m *CommentModel) ForSnippet(ctx context.Context, snippetID int) ([]models.Comment, error) {
+	switch snippetID {
+	case 1:
+		return mockComments, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (m *CommentModel) Update(ctx context.Context, id int, body string, status models.CommentStatus) error {
+	_, err := m.Get(ctx, id)
+	return err
+}
+
+func (m *CommentModel) SetStatus(ctx context.Context, id int, status models.CommentStatus) error {
+	_, err := m.Get(ctx, id)
+	return err
+}
+
+func (m *CommentModel) Delete(ctx context.Context, id int) error {
+	_, err := m.Get(ctx, id)
+	return err
+}
+
+func (m *CommentModel) DeleteExpired(ctx context.Context) (int64, error) {
+	return 0, nil
+}
@@ -0,0 +1,55 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com/vishal-rfx/snippetbox/internal/models"
+)
+
+// RememberTokenModel records the series of the tokens which are deleted, so that tests can check them.
+type RememberTokenModel struct {
+	Deleted []string
+}
+
+func (m *RememberTokenModel) Insert(ctx context.Context, userID int, sessionToken string, expires time.Time) (models.RememberToken, error) {
+	return models.RememberToken{
+		Series:    "series",
+		Validator: "validator",
+		UserID:    userID,
+		Expires:   expires,
+	}, nil
+}
+
+func (m *RememberTokenModel) Rotate(ctx context.Context, series, validator string) (models.RememberToken, error) {
+	switch {
+	case series == "series" && validator == "validator":
+		return models.RememberToken{
+			Series:    series,
+			Validator: "rotated",
+			UserID:    1,
+			Expires:   time.Now().Add(time.Hour),
+		}, nil
+	case series == "series":
+		return models.RememberToken{UserID: 1}, models.ErrRememberTokenReused
+	default:
+		return models.RememberToken{}, models.ErrNoRecord
+	}
+}
+
+func (m *RememberTokenModel) SetSessionToken(ctx context.Context, series, sessionToken string) error {
+	return nil
+}
+
+func (m *RememberTokenModel) Delete(ctx context.Context, series string) error {
+	m.Deleted = append(m.Deleted, series)
+	return nil
+}
+
+func (m *RememberTokenModel) DeleteBySessionToken(ctx context.Context, sessionToken string) error {
+	return nil
+}
+
+func (m *RememberTokenModel) DeleteAllForUser(ctx context.Context, userID int, exceptSeries string) error {
+	return nil
+}
@@ -0,0 +1,53 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com/vishal-rfx/snippetbox/internal/models"
+)
+
+var mockUserSession = models.UserSession{
+	ID:        1,
+	UserID:    1,
+	Token:     "other-device-token",
+	UserAgent: "Conference Room Browser",
+	IP:        "192.0.2.10",
+	Created:   time.Now(),
+	LastSeen:  time.Now(),
+	Expires:   time.Now().Add(time.Hour),
+}
+
+type UserSessionModel struct{}
+
+func (m *UserSessionModel) Insert(ctx context.Context, token string, userID int, userAgent, ip string, expires time.Time) error {
+	return nil
+}
+
+func (m *UserSessionModel) Touch(ctx context.Context, token, ip string) error {
+	return nil
+}
+
+func (m *UserSessionModel) Get(ctx context.Context, id, userID int) (models.UserSession, error) {
+	if id == 1 && userID == 1 {
+		return mockUserSession, nil
+	}
+
+	return models.UserSession{}, models.ErrNoRecord
+}
+
+func (m *UserSessionModel) GetAll(ctx context.Context, userID int) ([]models.UserSession, error) {
+	if userID == 1 {
+		return []models.UserSession{mockUserSession}, nil
+	}
+
+	return nil, nil
+}
+
+func (m *UserSessionModel) Delete(ctx context.Context, token string) error {
+	return nil
+}
+
+func (m *UserSessionModel) DeleteExpired(ctx context.Context) (int64, error) {
+	return 0, nil
+}
@@ -1,6 +1,7 @@
 package mocks
 
 import (
+	"context"
 	"time"
 
 	"github.com/vishal-rfx/snippetbox/internal/models"
@@ -9,27 +10,83 @@ import (
 
 var mockSnippet = models.Snippet{
 	ID : 1,
+	UserID: 1,
 	Title: "An old silent pond",
 	Content: "An old silent pond...",
 	Created: time.Now(),
 	Expires: time.Now(),
+	Tags: []string{"haiku"},
+	Files: []models.SnippetFile{
+		{Name: "pond.txt", Content: "An old silent pond...", Position: 0},
+		{Name: "frog.md", Language: "markdown", Content: "A frog jumps into the pond,", Position: 1},
+	},
+}
+
+var mockFork = models.Snippet{
+	ID: 3,
+	UserID: 3,
+	ParentID: 1,
+	Title: "An old noisy pond",
+	Created: time.Now(),
+	Expires: time.Now(),
+	Files: []models.SnippetFile{
+		{Name: "pond.txt", Content: "An old noisy pond...", Position: 0},
+	},
 }
 
 type SnippetModel struct {}
 
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
+func (m *SnippetModel) Insert(ctx context.Context, userID int, parentID int, title string, files []models.SnippetFile, expires int, tags []string) (int, error) {
 	return 2, nil
 }
 
-func (m *SnippetModel) Get(id int) (models.Snippet, error) {
+func (m *SnippetModel) Get(ctx context.Context, id int) (models.Snippet, error) {
 	switch id {
 	case 1:
 		return mockSnippet, nil
+	case 3:
+		return mockFork, nil
 	default:
 		return models.Snippet{}, models.ErrNoRecord
 	}
 }
 
-func (m *SnippetModel) Latest() ([]models.Snippet, error) {
-	return []models.Snippet{mockSnippet}, nil
-}
\ No newline at end of file
+func (m *SnippetModel) Forks(ctx context.Context, id int) ([]models.Snippet, error) {
+	switch id {
+	case 1:
+		return []models.Snippet{mockFork}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (m *SnippetModel) UserSnippetIDs(ctx context.Context, userID int) ([]int, error) {
+	switch userID {
+	case 1:
+		return []int{1}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (m *SnippetModel) Latest(ctx context.Context, tag string) ([]models.Snippet, error) {
+	switch tag {
+	case "", "haiku":
+		return []models.Snippet{mockSnippet}, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (m *SnippetModel) Tags(ctx context.Context) ([]models.TagCount, error) {
+	return []models.TagCount{{Name: "haiku", Count: 1}}, nil
+}
+
+func (m *SnippetModel) Delete(ctx context.Context, id int) error {
+	switch id {
+	case 1:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
@@ -1,10 +1,41 @@
 package mocks
 
-import "github.com/vishal-rfx/snippetbox/internal/models"
+import (
+	"context"
+	"time"
+
+	"github.com/vishal-rfx/snippetbox/internal/models"
+)
+
+var mockUser = models.User{
+	ID:      1,
+	Name:    "Alice Jones",
+	Email:   "alice@example.com",
+	Created: time.Now(),
+	Role:    models.RoleUser,
+}
+
+var mockAdmin = models.User{
+	ID:      3,
+	Name:    "Admin",
+	Email:   "admin@example.com",
+	Created: time.Now(),
+	Role:    models.RoleAdmin,
+}
+
+// mockExternalUser logged in through an external provider, so they don't have a password.
+var mockExternalUser = models.User{
+	ID:       4,
+	Name:     "Bob Smith",
+	Email:    "bob@example.com",
+	Created:  time.Now(),
+	Role:     models.RoleUser,
+	External: true,
+}
 
 type UserModel struct{}
 
-func (m *UserModel) Insert(name, email, password string) error {
+func (m *UserModel) Insert(ctx context.Context, name, email, password string) error {
 	switch email {
 		case "dupe@example.com":
 			return models.ErrDuplicateEmail
@@ -13,19 +44,109 @@ func (m *UserModel) Insert(name, email, password string) error {
 	}
 }
 
-func (m *UserModel) Authenticate(email, password string) (int, error) {
-	if email == "alice@example.com" && password == "password" {
-		return 1, nil
+func (m *UserModel) Authenticate(ctx context.Context, email, password string) (int, error) {
+	if password != "password" {
+		return 0, models.ErrInvalidCredentials
 	}
 
-	return 0, models.ErrInvalidCredentials
+	switch email {
+	case "alice@example.com":
+		return 1, nil
+	case "admin@example.com":
+		return 3, nil
+	case "disabled@example.com":
+		return 0, models.ErrAccountDisabled
+	default:
+		return 0, models.ErrInvalidCredentials
+	}
 }
 
-func (m *UserModel) Exists(id int)(bool, error){
+func (m *UserModel) Exists(ctx context.Context, id int)(bool, error){
 	switch id {
-	case 1:
+	case 1, 3, 4:
 		return true, nil
 	default:
 		return false, nil
 	}
-}
\ No newline at end of file
+}
+
+func (m *UserModel) UpsertExternal(ctx context.Context, provider, subject, name, email string) (int, error) {
+	switch email {
+	case mockUser.Email:
+		return 0, models.ErrLocalAccount
+	case mockExternalUser.Email:
+		return mockExternalUser.ID, nil
+	case "dupe@example.com":
+		return 0, models.ErrDuplicateEmail
+	default:
+		return 2, nil
+	}
+}
+
+func (m *UserModel) LinkExternal(ctx context.Context, provider, subject string, userID int) error {
+	if subject == "taken" {
+		return models.ErrIdentityInUse
+	}
+
+	return m.exists(userID)
+}
+
+func (m *UserModel) Get(ctx context.Context, id int) (models.User, error) {
+	switch id {
+	case 1:
+		return mockUser, nil
+	case 3:
+		return mockAdmin, nil
+	case 4:
+		return mockExternalUser, nil
+	default:
+		return models.User{}, models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) GetByEmail(ctx context.Context, email string) (models.User, error) {
+	switch email {
+	case mockUser.Email:
+		return mockUser, nil
+	case mockAdmin.Email:
+		return mockAdmin, nil
+	default:
+		return models.User{}, models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) Search(ctx context.Context, query string) ([]models.User, error) {
+	return []models.User{mockExternalUser, mockAdmin, mockUser}, nil
+}
+
+func (m *UserModel) SetRole(ctx context.Context, id int, role models.Role) error {
+	return m.exists(id)
+}
+
+func (m *UserModel) SetDisabled(ctx context.Context, id int, disabled bool) error {
+	return m.exists(id)
+}
+
+func (m *UserModel) RequirePasswordReset(ctx context.Context, id int) error {
+	if id == mockExternalUser.ID {
+		return models.ErrExternalUser
+	}
+
+	return m.exists(id)
+}
+
+func (m *UserModel) PasswordUpdate(ctx context.Context, id int, currentPassword, newPassword string) error {
+	if currentPassword != "password" {
+		return models.ErrInvalidCredentials
+	}
+
+	return m.exists(id)
+}
+
+func (m *UserModel) exists(id int) error {
+	if ok, _ := m.Exists(context.Background(), id); !ok {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
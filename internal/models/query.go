@@ -0,0 +1,40 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer is looked up from the global tracer provider on each use, so it picks up whatever provider main()
+// installs. If tracing isn't configured the global provider is a no-op and spans cost next to nothing.
+var tracer = otel.Tracer("github.com/vishal-rfx/snippetbox/internal/models")
+
+// startSpan starts a child span of the span in ctx for a database operation. Callers must end the span.
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "mysql")),
+	)
+}
+
+// startQuery prepares the context for a model method which queries the database. It starts a span for the
+// method and, if timeout isn't zero, adds a deadline so that the queries are cancelled if the database is
+// too slow. The queries are also cancelled if ctx is, for example when the client goes away. Callers must
+// call done when they've finished with the database.
+func startQuery(ctx context.Context, name string, timeout time.Duration) (context.Context, func()) {
+	ctx, span := startSpan(ctx, name)
+
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
+	return ctx, func() {
+		cancel()
+		span.End()
+	}
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+func TestStartQuery(t *testing.T) {
+	t.Run("With timeout", func(t *testing.T) {
+		ctx, done := startQuery(context.Background(), "test", time.Second)
+
+		deadline, ok := ctx.Deadline()
+		assert.Equal(t, ok, true)
+		assert.Equal(t, time.Until(deadline) <= time.Second, true)
+
+		done()
+		assert.Equal(t, ctx.Err(), context.Canceled)
+	})
+
+	t.Run("Without timeout", func(t *testing.T) {
+		ctx, done := startQuery(context.Background(), "test", 0)
+		defer done()
+
+		_, ok := ctx.Deadline()
+		assert.Equal(t, ok, false)
+	})
+
+	t.Run("Parent cancelled", func(t *testing.T) {
+		parent, cancel := context.WithCancel(context.Background())
+		ctx, done := startQuery(parent, "test", time.Second)
+		defer done()
+
+		cancel()
+		assert.Equal(t, ctx.Err(), context.Canceled)
+	})
+}
@@ -0,0 +1,205 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/vishal-rfx/snippetbox/internal/random"
+)
+
+// rememberTokenGracePeriod is how long the previous validator for a series is still accepted after it has
+// been rotated. Browsers often fire several requests in parallel with the same cookie, and without this the
+// losers of that race would look exactly like a stolen token being replayed.
+const rememberTokenGracePeriod = 30 * time.Second
+
+// RememberToken represents a long-lived "remember me" token. The Series is a random public identifier for
+// the token, and the Validator is the secret half which we only ever store as a SHA-256 hash. The Validator
+// field is only populated when a token is created or rotated, so that it can be sent to the client.
+type RememberToken struct {
+	Series    string
+	Validator string
+	UserID    int
+	Expires   time.Time
+}
+
+type RememberTokenModelInterface interface {
+	Insert(ctx context.Context, userID int, sessionToken string, expires time.Time) (RememberToken, error)
+	Rotate(ctx context.Context, series, validator string) (RememberToken, error)
+	SetSessionToken(ctx context.Context, series, sessionToken string) error
+	Delete(ctx context.Context, series string) error
+	DeleteBySessionToken(ctx context.Context, sessionToken string) error
+	DeleteAllForUser(ctx context.Context, userID int, exceptSeries string) error
+}
+
+// RememberTokenModel type which wraps a sql.DB connection pool
+type RememberTokenModel struct {
+	DB *sql.DB
+	// QueryTimeout limits how long each method can spend waiting on the database. Zero means no limit.
+	QueryTimeout time.Duration
+}
+
+// Insert creates a new remember token for a user, linked to the session it was issued alongside.
+func (m *RememberTokenModel) Insert(ctx context.Context, userID int, sessionToken string, expires time.Time) (RememberToken, error) {
+	ctx, done := startQuery(ctx, "RememberTokenModel.Insert", m.QueryTimeout)
+	defer done()
+
+	series, err := random.Token()
+	if err != nil {
+		return RememberToken{}, err
+	}
+
+	validator, err := random.Token()
+	if err != nil {
+		return RememberToken{}, err
+	}
+
+	stmt := `
+		INSERT INTO remember_tokens (series, token_hash, prev_token_hash, user_id, session_token, created, rotated, expires)
+		VALUES (?, ?, '', ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP(), ?)
+	`
+
+	_, err = m.DB.ExecContext(ctx, stmt, series, hashToken(validator), userID, sessionToken, expires.UTC())
+	if err != nil {
+		return RememberToken{}, err
+	}
+
+	return RememberToken{Series: series, Validator: validator, UserID: userID, Expires: expires.UTC()}, nil
+}
+
+// Rotate checks a series and validator pair presented by a client and, if they are valid, replaces the
+// validator with a new one which is returned in the RememberToken. If the series doesn't exist or has
+// expired we return ErrNoRecord.
+//
+// If the series exists but the validator doesn't match, then somebody is presenting an old validator which
+// has already been rotated. This means that the token has been stolen and used by either the attacker or the
+// legitimate user, and we have no way to tell which. So we delete every remember token for the user and
+// return ErrRememberTokenReused along with the affected user ID, so that the caller can log them out too.
+func (m *RememberTokenModel) Rotate(ctx context.Context, series, validator string) (RememberToken, error) {
+	ctx, done := startQuery(ctx, "RememberTokenModel.Rotate", m.QueryTimeout)
+	defer done()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return RememberToken{}, err
+	}
+	// Rollback is a no-op if the transaction has already been committed.
+	defer tx.Rollback()
+
+	stmt := `SELECT user_id, token_hash, prev_token_hash, rotated, expires
+			 FROM remember_tokens
+			 WHERE expires > UTC_TIMESTAMP() AND series = ?
+			 FOR UPDATE`
+
+	var t RememberToken
+	var tokenHash, prevTokenHash string
+	var rotated time.Time
+
+	err = tx.QueryRowContext(ctx, stmt, series).Scan(&t.UserID, &tokenHash, &prevTokenHash, &rotated, &t.Expires)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return RememberToken{}, ErrNoRecord
+		}
+		return RememberToken{}, err
+	}
+
+	t.Series = series
+	presented := hashToken(validator)
+
+	switch {
+	case subtle.ConstantTimeCompare([]byte(presented), []byte(tokenHash)) == 1:
+		t.Validator, err = random.Token()
+		if err != nil {
+			return RememberToken{}, err
+		}
+
+		stmt = `UPDATE remember_tokens SET token_hash = ?, prev_token_hash = ?, rotated = UTC_TIMESTAMP() WHERE series = ?`
+		_, err = tx.ExecContext(ctx, stmt, hashToken(t.Validator), tokenHash, series)
+		if err != nil {
+			return RememberToken{}, err
+		}
+
+	case prevTokenHash != "" &&
+		subtle.ConstantTimeCompare([]byte(presented), []byte(prevTokenHash)) == 1 &&
+		time.Since(rotated) < rememberTokenGracePeriod:
+		// A parallel request with the same cookie has only just rotated this token. Accept it, but don't
+		// issue a new validator as the client should already have received one.
+
+	default:
+		_, err = tx.ExecContext(ctx, `DELETE FROM remember_tokens WHERE user_id = ?`, t.UserID)
+		if err != nil {
+			return RememberToken{}, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return RememberToken{}, err
+		}
+
+		return RememberToken{UserID: t.UserID}, ErrRememberTokenReused
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return RememberToken{}, err
+	}
+
+	return t, nil
+}
+
+// SetSessionToken links a remember token to the session that it has just re-established.
+func (m *RememberTokenModel) SetSessionToken(ctx context.Context, series, sessionToken string) error {
+	ctx, done := startQuery(ctx, "RememberTokenModel.SetSessionToken", m.QueryTimeout)
+	defer done()
+
+	stmt := `UPDATE remember_tokens SET session_token = ? WHERE series = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt, sessionToken, series)
+	return err
+}
+
+// Delete removes a single remember token.
+func (m *RememberTokenModel) Delete(ctx context.Context, series string) error {
+	ctx, done := startQuery(ctx, "RememberTokenModel.Delete", m.QueryTimeout)
+	defer done()
+
+	stmt := `DELETE FROM remember_tokens WHERE series = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt, series)
+	return err
+}
+
+// DeleteBySessionToken removes the remember token linked to a session, so that revoking the session can't be
+// undone by the device transparently logging back in.
+func (m *RememberTokenModel) DeleteBySessionToken(ctx context.Context, sessionToken string) error {
+	ctx, done := startQuery(ctx, "RememberTokenModel.DeleteBySessionToken", m.QueryTimeout)
+	defer done()
+
+	stmt := `DELETE FROM remember_tokens WHERE session_token = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt, sessionToken)
+	return err
+}
+
+// DeleteAllForUser removes every remember token for a user, apart from the one with the given series (pass
+// an empty string to remove them all).
+func (m *RememberTokenModel) DeleteAllForUser(ctx context.Context, userID int, exceptSeries string) error {
+	ctx, done := startQuery(ctx, "RememberTokenModel.DeleteAllForUser", m.QueryTimeout)
+	defer done()
+
+	stmt := `DELETE FROM remember_tokens WHERE user_id = ? AND series <> ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt, userID, exceptSeries)
+	return err
+}
+
+// hashToken returns the hex-encoded SHA-256 hash of a token. The tokens we hash are long random strings, so
+// unlike passwords there's no need for a slow hash like bcrypt.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+// countRememberTokens returns how many remember tokens a user has.
+func countRememberTokens(t *testing.T, m *RememberTokenModel, userID int) int {
+	var n int
+	err := m.DB.QueryRow(`SELECT COUNT(*) FROM remember_tokens WHERE user_id = ?`, userID).Scan(&n)
+	assert.NilError(t, err)
+	return n
+}
+
+func TestRememberTokenModelRotate(t *testing.T) {
+	db := newTestDB(t)
+	m := &RememberTokenModel{DB: db}
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour)
+
+	token, err := m.Insert(ctx, 1, testSessionToken('a'), expires)
+	assert.NilError(t, err)
+
+	rotated, err := m.Rotate(ctx, token.Series, token.Validator)
+	assert.NilError(t, err)
+	assert.Equal(t, rotated.Series, token.Series)
+	assert.Equal(t, rotated.UserID, 1)
+	assert.Equal(t, rotated.Validator == "" || rotated.Validator == token.Validator, false)
+
+	// The new validator can be used in turn.
+	again, err := m.Rotate(ctx, token.Series, rotated.Validator)
+	assert.NilError(t, err)
+	assert.Equal(t, again.UserID, 1)
+
+	_, err = m.Rotate(ctx, "unknown", token.Validator)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	expired, err := m.Insert(ctx, 1, testSessionToken('b'), time.Now().Add(-time.Hour))
+	assert.NilError(t, err)
+
+	_, err = m.Rotate(ctx, expired.Series, expired.Validator)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+}
+
+func TestRememberTokenModelRotateReplay(t *testing.T) {
+	db := newTestDB(t)
+	m := &RememberTokenModel{DB: db}
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour)
+
+	token, err := m.Insert(ctx, 1, testSessionToken('a'), expires)
+	assert.NilError(t, err)
+	_, err = m.Insert(ctx, 1, testSessionToken('b'), expires)
+	assert.NilError(t, err)
+	_, err = m.Insert(ctx, 2, testSessionToken('c'), expires)
+	assert.NilError(t, err)
+
+	_, err = m.Rotate(ctx, token.Series, token.Validator)
+	assert.NilError(t, err)
+
+	// A parallel request presenting the old validator within the grace period is let through, without
+	// another new validator.
+	replayed, err := m.Rotate(ctx, token.Series, token.Validator)
+	assert.NilError(t, err)
+	assert.Equal(t, replayed.UserID, 1)
+	assert.Equal(t, replayed.Validator, "")
+	assert.Equal(t, countRememberTokens(t, m, 1), 2)
+
+	// After the grace period the old validator means the token has been stolen, so all of the user's tokens
+	// are deleted, and nobody else's.
+	_, err = db.Exec(`UPDATE remember_tokens SET rotated = DATE_SUB(rotated, INTERVAL 1 MINUTE) WHERE series = ?`, token.Series)
+	assert.NilError(t, err)
+
+	replayed, err = m.Rotate(ctx, token.Series, token.Validator)
+	assert.Equal(t, errors.Is(err, ErrRememberTokenReused), true)
+	assert.Equal(t, replayed.UserID, 1)
+	assert.Equal(t, countRememberTokens(t, m, 1), 0)
+	assert.Equal(t, countRememberTokens(t, m, 2), 1)
+}
+
+func TestRememberTokenModelRotateWrongValidator(t *testing.T) {
+	db := newTestDB(t)
+	m := &RememberTokenModel{DB: db}
+	ctx := context.Background()
+
+	token, err := m.Insert(ctx, 1, testSessionToken('a'), time.Now().Add(time.Hour))
+	assert.NilError(t, err)
+
+	// A validator which never belonged to the series is treated as a replay too.
+	_, err = m.Rotate(ctx, token.Series, "not-the-validator")
+	assert.Equal(t, errors.Is(err, ErrRememberTokenReused), true)
+	assert.Equal(t, countRememberTokens(t, m, 1), 0)
+}
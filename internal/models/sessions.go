@@ -0,0 +1,168 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// UserSession holds the metadata that we record alongside each authenticated scs session, so that a user
+// can see where they are logged in. The Token field is the scs session token and must never be rendered
+// into a page; the ID field is what we expose to the user instead.
+type UserSession struct {
+	ID        int
+	UserID    int
+	Token     string
+	UserAgent string
+	IP        string
+	Created   time.Time
+	LastSeen  time.Time
+	Expires   time.Time
+}
+
+type UserSessionModelInterface interface {
+	Insert(ctx context.Context, token string, userID int, userAgent, ip string, expires time.Time) error
+	Touch(ctx context.Context, token, ip string) error
+	Get(ctx context.Context, id, userID int) (UserSession, error)
+	GetAll(ctx context.Context, userID int) ([]UserSession, error)
+	Delete(ctx context.Context, token string) error
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// UserSessionModel type which wraps a sql.DB connection pool
+type UserSessionModel struct {
+	DB *sql.DB
+	// QueryTimeout limits how long each method can spend waiting on the database. Zero means no limit.
+	QueryTimeout time.Duration
+}
+
+// Insert records a newly authenticated session for the given user.
+func (m *UserSessionModel) Insert(ctx context.Context, token string, userID int, userAgent, ip string, expires time.Time) error {
+	ctx, done := startQuery(ctx, "UserSessionModel.Insert", m.QueryTimeout)
+	defer done()
+
+	stmt := `
+		INSERT INTO user_sessions (token, user_id, user_agent, ip, created, last_seen, expires)
+		VALUES (?, ?, ?, ?, UTC_TIMESTAMP(), UTC_TIMESTAMP(), ?)
+	`
+
+	// The user_agent column is a VARCHAR(255), so truncate anything longer rather than failing the login.
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+
+	_, err := m.DB.ExecContext(ctx, stmt, token, userID, userAgent, ip, expires.UTC())
+	return err
+}
+
+// Touch updates the last seen time and IP address for a session. To avoid writing to the database on every
+// single request, the row is only updated if it hasn't been touched in the last minute.
+func (m *UserSessionModel) Touch(ctx context.Context, token, ip string) error {
+	ctx, done := startQuery(ctx, "UserSessionModel.Touch", m.QueryTimeout)
+	defer done()
+
+	stmt := `
+		UPDATE user_sessions SET last_seen = UTC_TIMESTAMP(), ip = ?
+		WHERE token = ? AND last_seen < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 MINUTE)
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, ip, token)
+	return err
+}
+
+// Get returns a specific live session, but only if it belongs to the given user. A session is live if it
+// hasn't passed its absolute expiry and it's still in the session store, which it won't be if it timed out.
+func (m *UserSessionModel) Get(ctx context.Context, id, userID int) (UserSession, error) {
+	ctx, done := startQuery(ctx, "UserSessionModel.Get", m.QueryTimeout)
+	defer done()
+
+	stmt := `SELECT us.id, us.user_id, us.token, us.user_agent, us.ip, us.created, us.last_seen, us.expires
+			 FROM user_sessions us
+			 INNER JOIN sessions s ON s.token = us.token
+			 WHERE us.expires > UTC_TIMESTAMP() AND s.expiry > UTC_TIMESTAMP(6) AND us.id = ? AND us.user_id = ?`
+
+	var s UserSession
+	err := m.DB.QueryRowContext(ctx, stmt, id, userID).Scan(&s.ID, &s.UserID, &s.Token, &s.UserAgent, &s.IP, &s.Created, &s.LastSeen, &s.Expires)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return UserSession{}, ErrNoRecord
+		}
+		return UserSession{}, err
+	}
+
+	return s, nil
+}
+
+// GetAll returns all the live sessions for a user, most recently active first.
+func (m *UserSessionModel) GetAll(ctx context.Context, userID int) ([]UserSession, error) {
+	ctx, done := startQuery(ctx, "UserSessionModel.GetAll", m.QueryTimeout)
+	defer done()
+
+	stmt := `
+		SELECT us.id, us.user_id, us.token, us.user_agent, us.ip, us.created, us.last_seen, us.expires
+		FROM user_sessions us
+		INNER JOIN sessions s ON s.token = us.token
+		WHERE us.expires > UTC_TIMESTAMP() AND s.expiry > UTC_TIMESTAMP(6) AND us.user_id = ?
+		ORDER BY us.last_seen DESC
+	`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []UserSession
+	for rows.Next() {
+		var s UserSession
+		err = rows.Scan(&s.ID, &s.UserID, &s.Token, &s.UserAgent, &s.IP, &s.Created, &s.LastSeen, &s.Expires)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Delete removes the metadata for a session. Note that this does not remove the session itself from the
+// session store, that is the responsibility of the caller.
+func (m *UserSessionModel) Delete(ctx context.Context, token string) error {
+	ctx, done := startQuery(ctx, "UserSessionModel.Delete", m.QueryTimeout)
+	defer done()
+
+	stmt := `DELETE FROM user_sessions WHERE token = ?`
+
+	_, err := m.DB.ExecContext(ctx, stmt, token)
+	return err
+}
+
+// DeleteExpired removes the metadata for sessions which have expired or are no longer in the session store,
+// and returns how many were removed. The session is only saved to the store at the end of the login
+// request, after its metadata has been inserted, so recently created rows are left alone.
+func (m *UserSessionModel) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, done := startQuery(ctx, "UserSessionModel.DeleteExpired", m.QueryTimeout)
+	defer done()
+
+	stmt := `
+		DELETE FROM user_sessions
+		WHERE expires <= UTC_TIMESTAMP() OR (
+			created < DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 MINUTE) AND NOT EXISTS (
+				SELECT 1 FROM sessions s WHERE s.token = user_sessions.token AND s.expiry > UTC_TIMESTAMP(6)
+			)
+		)
+	`
+
+	result, err := m.DB.ExecContext(ctx, stmt)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+// testSessionToken returns a session token of the same length as the tokens scs generates.
+func testSessionToken(c byte) string {
+	return strings.Repeat(string(c), 43)
+}
+
+// insertTestStoreSession adds a session to the session store's table, as scs would when it saves a session.
+func insertTestStoreSession(t *testing.T, db *sql.DB, token string, expiry time.Time) {
+	_, err := db.Exec(`INSERT INTO sessions (token, data, expiry) VALUES (?, ?, ?)`, token, []byte("data"), expiry.UTC())
+	assert.NilError(t, err)
+}
+
+// newTestSessions returns a UserSessionModel with three live sessions: two for Alice (user 1), one of which
+// was last seen an hour ago, and one for user 2. Alice also has an expired session, and a session which was
+// created an hour ago but is no longer in the session store because it timed out.
+func newTestSessions(t *testing.T) *UserSessionModel {
+	db := newTestDB(t)
+	m := &UserSessionModel{DB: db}
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour)
+
+	assert.NilError(t, m.Insert(ctx, testSessionToken('a'), 1, "Firefox", "192.0.2.1", expires))
+	assert.NilError(t, m.Insert(ctx, testSessionToken('b'), 1, "curl", "192.0.2.2", expires))
+	assert.NilError(t, m.Insert(ctx, testSessionToken('c'), 2, "Safari", "192.0.2.3", expires))
+	assert.NilError(t, m.Insert(ctx, testSessionToken('d'), 1, "Chrome", "192.0.2.4", time.Now().Add(-time.Hour)))
+	assert.NilError(t, m.Insert(ctx, testSessionToken('f'), 1, "Edge", "192.0.2.6", expires))
+
+	for _, c := range []byte("abc") {
+		insertTestStoreSession(t, db, testSessionToken(c), expires)
+	}
+
+	_, err := db.Exec(`UPDATE user_sessions SET last_seen = DATE_SUB(last_seen, INTERVAL 1 HOUR) WHERE token = ?`, testSessionToken('a'))
+	assert.NilError(t, err)
+
+	_, err = db.Exec(`UPDATE user_sessions SET created = DATE_SUB(created, INTERVAL 1 HOUR) WHERE token = ?`, testSessionToken('f'))
+	assert.NilError(t, err)
+
+	return m
+}
+
+func TestUserSessionModelGetAll(t *testing.T) {
+	m := newTestSessions(t)
+
+	// The expired and timed out sessions are left out, and the most recently active session comes first.
+	sessions, err := m.GetAll(context.Background(), 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(sessions), 2)
+	assert.Equal(t, sessions[0].UserAgent, "curl")
+	assert.Equal(t, sessions[1].UserAgent, "Firefox")
+
+	sessions, err = m.GetAll(context.Background(), 3)
+	assert.NilError(t, err)
+	assert.Equal(t, len(sessions), 0)
+}
+
+func TestUserSessionModelGet(t *testing.T) {
+	m := newTestSessions(t)
+
+	sessions, err := m.GetAll(context.Background(), 1)
+	assert.NilError(t, err)
+	id := sessions[0].ID
+
+	s, err := m.Get(context.Background(), id, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, s.Token, testSessionToken('b'))
+	assert.Equal(t, s.IP, "192.0.2.2")
+
+	// Users can't get at other people's sessions, so they can't revoke them either.
+	_, err = m.Get(context.Background(), id, 2)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+
+	_, err = m.Get(context.Background(), 999, 1)
+	assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+}
+
+func TestUserSessionModelTouch(t *testing.T) {
+	m := newTestSessions(t)
+	ctx := context.Background()
+
+	// The session which was last seen an hour ago is updated, but the one which was just created isn't.
+	assert.NilError(t, m.Touch(ctx, testSessionToken('a'), "198.51.100.1"))
+	assert.NilError(t, m.Touch(ctx, testSessionToken('b'), "198.51.100.2"))
+
+	sessions, err := m.GetAll(ctx, 1)
+	assert.NilError(t, err)
+	ips := map[string]string{}
+	for _, s := range sessions {
+		ips[s.UserAgent] = s.IP
+	}
+	assert.Equal(t, ips["Firefox"], "198.51.100.1")
+	assert.Equal(t, ips["curl"], "192.0.2.2")
+}
+
+func TestUserSessionModelDelete(t *testing.T) {
+	m := newTestSessions(t)
+	ctx := context.Background()
+
+	assert.NilError(t, m.Delete(ctx, testSessionToken('b')))
+
+	sessions, err := m.GetAll(ctx, 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(sessions), 1)
+	assert.Equal(t, sessions[0].Token, testSessionToken('a'))
+
+	// Other users' sessions are untouched.
+	sessions, err = m.GetAll(ctx, 2)
+	assert.NilError(t, err)
+	assert.Equal(t, len(sessions), 1)
+
+	// Deleting a session which has already gone isn't an error.
+	assert.NilError(t, m.Delete(ctx, testSessionToken('b')))
+}
+
+func TestUserSessionModelDeleteExpired(t *testing.T) {
+	m := newTestSessions(t)
+	ctx := context.Background()
+
+	// A session which has just been created isn't in the session store yet, as it's only saved at the end
+	// of the login request, so it must be left alone.
+	assert.NilError(t, m.Insert(ctx, testSessionToken('g'), 1, "Opera", "192.0.2.7", time.Now().Add(time.Hour)))
+
+	n, err := m.DeleteExpired(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, n, int64(2))
+
+	var tokens []string
+	rows, err := m.DB.Query(`SELECT token FROM user_sessions ORDER BY token`)
+	assert.NilError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var token string
+		assert.NilError(t, rows.Scan(&token))
+		tokens = append(tokens, token[:1])
+	}
+	assert.NilError(t, rows.Err())
+	assert.Equal(t, strings.Join(tokens, ""), "abcg")
+}
+
+func TestUserSessionModelInsertLongUserAgent(t *testing.T) {
+	db := newTestDB(t)
+	m := &UserSessionModel{DB: db}
+
+	expires := time.Now().Add(time.Hour)
+	err := m.Insert(context.Background(), testSessionToken('e'), 1, strings.Repeat("x", 300), "192.0.2.5", expires)
+	assert.NilError(t, err)
+	insertTestStoreSession(t, db, testSessionToken('e'), expires)
+
+	sessions, err := m.GetAll(context.Background(), 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(sessions[0].UserAgent), 255)
+}
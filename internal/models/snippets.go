@@ -1,44 +1,74 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
 )
 
-// Snippet type holds the data for an individual snippet.
+// Snippet type holds the data for an individual snippet. UserID is the user who created it, or 0 for
+// snippets created before we kept track of this, and ParentID is the snippet it was forked from, or 0.
+// Content only holds the text of snippets which were created before snippets could have more than one
+// file; Get() returns it as a single file named DefaultFilename, so use Files instead.
 type Snippet struct {
-	ID      int
-	Title   string
-	Content string
-	Created time.Time
-	Expires time.Time
+	ID       int
+	UserID   int
+	ParentID int
+	Title    string
+	Content  string
+	Created  time.Time
+	Expires  time.Time
+	Tags     []string
+	Files    []SnippetFile
 }
 
 
 type SnippetModelInterface interface {
-	Insert(title string, content string, expires int) (int, error)
-	Get(id int) (Snippet, error)
-	Latest() ([]Snippet, error)
+	Insert(ctx context.Context, userID int, parentID int, title string, files []SnippetFile, expires int, tags []string) (int, error)
+	Get(ctx context.Context, id int) (Snippet, error)
+	UserSnippetIDs(ctx context.Context, userID int) ([]int, error)
+	Latest(ctx context.Context, tag string) ([]Snippet, error)
+	Forks(ctx context.Context, id int) ([]Snippet, error)
+	Tags(ctx context.Context) ([]TagCount, error)
+	Delete(ctx context.Context, id int) error
 }
 
 // SnippetModel type which wraps a sql.DB connection pool
 type SnippetModel struct {
 	DB *sql.DB
+	// QueryTimeout limits how long each method can spend waiting on the database. Zero means no limit.
+	QueryTimeout time.Duration
 }
 
-// Insert will insert a new snippet into the database.
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
+// Insert will insert a new snippet created by the user with the given ID into the database, along with its
+// files and tags. If the snippet is a fork, parentID is the ID of the snippet it was forked from, otherwise
+// it's 0. The files are stored in the order given, and the tags should already have been normalized and
+// validated.
+func (m *SnippetModel) Insert(ctx context.Context, userID int, parentID int, title string, files []SnippetFile, expires int, tags []string) (int, error) {
+	ctx, done := startQuery(ctx, "SnippetModel.Insert", m.QueryTimeout)
+	defer done()
+
+	// The snippet, its files and its tags are inserted in a transaction, so that we never end up with a
+	// snippet which is missing some of them.
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
 	stmt := `
-		INSERT INTO snippets (title, content, created, expires)
-		VALUES (?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY) )
+		INSERT INTO snippets (user_id, parent_id, title, content, created, expires)
+		VALUES (?, ?, ?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY) )
 	`
 
-	// Use the Exec() method on the embedded connection pool to execute the
-	// statement, followed by the values for the placeholder parameters: title, content and expiry in that order.
+	// Use the Exec() method on the transaction to execute the
+	// statement, followed by the values for the placeholder parameters: user ID, parent ID, title, content and expiry in that order.
+	// The content is kept in the snippet_files table now, so the content column is left empty.
 	// This method returns a sql.Result type which contains some
 	// basic information about what happened when the statement was executed.
-	result, err := m.DB.Exec(stmt, title, content, expires)
+	result, err := tx.ExecContext(ctx, stmt, userID, parentID, title, "", expires)
 	if err != nil {
 		return 0, err
 	}
@@ -48,22 +78,61 @@ func (m *SnippetModel) Insert(title string, content string, expires int) (int, e
 		return 0, err
 	}
 
+	for i, f := range files {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO snippet_files (snippet_id, position, name, language, content)
+			VALUES (?, ?, ?, ?, ?)
+		`, id, i, f.Name, f.Language, f.Content)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	for _, tag := range tags {
+		// Tags are shared between snippets, so only insert the tag if it doesn't exist yet. Setting the id
+		// to LAST_INSERT_ID(id) when it does means that LastInsertId() returns the existing tag's ID.
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO tags (name) VALUES (?)
+			ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)
+		`, tag)
+		if err != nil {
+			return 0, err
+		}
+		tagID, err := result.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO snippet_tags (snippet_id, tag_id) VALUES (?, ?)`, id, tagID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
 	return int(id), nil
 }
 
 // Get will return a specific snippet based on its id.
-func (m *SnippetModel) Get(id int) (Snippet, error) {
-	stmt := `SELECT id, title, content, created, expires
+func (m *SnippetModel) Get(ctx context.Context, id int) (Snippet, error) {
+	ctx, done := startQuery(ctx, "SnippetModel.Get", m.QueryTimeout)
+	defer done()
+
+	stmt := `SELECT id, user_id, parent_id, title, content, created, expires
 			 FROM snippets
 			 WHERE expires > UTC_TIMESTAMP() and id = ?`
 
-	row := m.DB.QueryRow(stmt, id)
+	row := m.DB.QueryRowContext(ctx, stmt, id)
 
 	var s Snippet
 	// Use row.Scan() to copy the values from each field in sql.Row to the corresponding field in the Snippet struct.
 	// The arguments to row.Scan are *pointers* to the place you want to copy the data into,
 	// and the number of arguments must be exactly the same as the number of columns returned by your statement
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	err := row.Scan(&s.ID, &s.UserID, &s.ParentID, &s.Title, &s.Content, &s.Created, &s.Expires)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows){
 			return Snippet{}, ErrNoRecord
@@ -72,19 +141,112 @@ func (m *SnippetModel) Get(id int) (Snippet, error) {
 		}
 	}
 
+	tags, err := m.snippetTags(ctx, []int{s.ID})
+	if err != nil {
+		return Snippet{}, err
+	}
+	s.Tags = tags[s.ID]
+
+	s.Files, err = m.files(ctx, s.ID)
+	if err != nil {
+		return Snippet{}, err
+	}
+	if len(s.Files) == 0 {
+		s.Files = []SnippetFile{{Name: DefaultFilename, Content: s.Content}}
+	}
+
 	return s, nil
 }
 
-// Latest will return the slice of 10 most recently created snippets
-func (m *SnippetModel) Latest() ([]Snippet, error) {
+// files returns the files in a snippet, in order.
+func (m *SnippetModel) files(ctx context.Context, id int) ([]SnippetFile, error) {
 	stmt := `
-		SELECT id, title, content, created, expires
+		SELECT name, language, content, position
+		FROM snippet_files
+		WHERE snippet_id = ?
+		ORDER BY position
+	`
+	rows, err := m.DB.QueryContext(ctx, stmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []SnippetFile
+	for rows.Next() {
+		var f SnippetFile
+		err = rows.Scan(&f.Name, &f.Language, &f.Content, &f.Position)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// UserSnippetIDs returns the IDs of all the unexpired snippets created by a user, oldest first. Only the IDs
+// are returned so that callers can work through a user's snippets one at a time, rather than loading all of
+// them into memory together.
+func (m *SnippetModel) UserSnippetIDs(ctx context.Context, userID int) ([]int, error) {
+	ctx, done := startQuery(ctx, "SnippetModel.UserSnippetIDs", m.QueryTimeout)
+	defer done()
+
+	stmt := `SELECT id FROM snippets WHERE user_id = ? AND expires > UTC_TIMESTAMP() ORDER BY id`
+
+	rows, err := m.DB.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// Latest will return the slice of 10 most recently created snippets. If tag isn't empty, only the snippets
+// with that tag are returned.
+func (m *SnippetModel) Latest(ctx context.Context, tag string) ([]Snippet, error) {
+	ctx, done := startQuery(ctx, "SnippetModel.Latest", m.QueryTimeout)
+	defer done()
+
+	stmt := `
+		SELECT id, user_id, parent_id, title, content, created, expires
 		FROM snippets
 		WHERE expires > UTC_TIMESTAMP() 
 		ORDER BY id DESC
 		LIMIT 10
 	`
-	rows, err := m.DB.Query(stmt)
+	args := []any{}
+	if tag != "" {
+		stmt = `
+			SELECT s.id, s.user_id, s.parent_id, s.title, s.content, s.created, s.expires
+			FROM snippets s
+			JOIN snippet_tags st ON st.snippet_id = s.id
+			JOIN tags t ON t.id = st.tag_id
+			WHERE s.expires > UTC_TIMESTAMP() AND t.name = ?
+			ORDER BY s.id DESC
+			LIMIT 10
+		`
+		args = append(args, tag)
+	}
+
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -101,7 +263,7 @@ func (m *SnippetModel) Latest() ([]Snippet, error) {
 
 	for rows.Next() {
 		var s Snippet
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		err = rows.Scan(&s.ID, &s.UserID, &s.ParentID, &s.Title, &s.Content, &s.Created, &s.Expires)
 		if err != nil {
 			return nil, err
 		}
@@ -117,5 +279,177 @@ func (m *SnippetModel) Latest() ([]Snippet, error) {
 		return nil, err
 	}
 
+	ids := make([]int, len(snippets))
+	for i, s := range snippets {
+		ids[i] = s.ID
+	}
+	tags, err := m.snippetTags(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range snippets {
+		snippets[i].Tags = tags[snippets[i].ID]
+	}
+
 	return snippets, nil
 }
+
+// Forks returns the unexpired snippets which were forked from the snippet with the given ID, newest first.
+// Only the latest 50 forks are returned, as popular snippets could have many more than we want to show on
+// a page. Their tags and files aren't loaded.
+func (m *SnippetModel) Forks(ctx context.Context, id int) ([]Snippet, error) {
+	ctx, done := startQuery(ctx, "SnippetModel.Forks", m.QueryTimeout)
+	defer done()
+
+	stmt := `
+		SELECT id, user_id, parent_id, title, content, created, expires
+		FROM snippets
+		WHERE parent_id = ? AND expires > UTC_TIMESTAMP()
+		ORDER BY id DESC
+		LIMIT 50
+	`
+	rows, err := m.DB.QueryContext(ctx, stmt, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var forks []Snippet
+	for rows.Next() {
+		var s Snippet
+		err = rows.Scan(&s.ID, &s.UserID, &s.ParentID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		if err != nil {
+			return nil, err
+		}
+		forks = append(forks, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return forks, nil
+}
+
+// Tags returns the 100 most used tags, with the number of unexpired snippets which have each of them. Tags
+// which are only used by expired snippets aren't included.
+func (m *SnippetModel) Tags(ctx context.Context) ([]TagCount, error) {
+	ctx, done := startQuery(ctx, "SnippetModel.Tags", m.QueryTimeout)
+	defer done()
+
+	stmt := `
+		SELECT t.name, COUNT(*)
+		FROM tags t
+		JOIN snippet_tags st ON st.tag_id = t.id
+		JOIN snippets s ON s.id = st.snippet_id
+		WHERE s.expires > UTC_TIMESTAMP()
+		GROUP BY t.name
+		ORDER BY COUNT(*) DESC, t.name
+		LIMIT 100
+	`
+	rows, err := m.DB.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []TagCount
+	for rows.Next() {
+		var c TagCount
+		err = rows.Scan(&c.Name, &c.Count)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// snippetTags returns the tags of each of the snippets with the given IDs, in alphabetical order.
+func (m *SnippetModel) snippetTags(ctx context.Context, ids []int) (map[int][]string, error) {
+	tags := map[int][]string{}
+	if len(ids) == 0 {
+		return tags, nil
+	}
+
+	placeholders := strings.Repeat("?, ", len(ids)-1) + "?"
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	stmt := `
+		SELECT st.snippet_id, t.name
+		FROM snippet_tags st
+		JOIN tags t ON t.id = st.tag_id
+		WHERE st.snippet_id IN (` + placeholders + `)
+		ORDER BY t.name
+	`
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var name string
+		err = rows.Scan(&id, &name)
+		if err != nil {
+			return nil, err
+		}
+		tags[id] = append(tags[id], name)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// Delete removes a snippet with its files, tags and comments, returning ErrNoRecord if it doesn't exist.
+func (m *SnippetModel) Delete(ctx context.Context, id int) error {
+	ctx, done := startQuery(ctx, "SnippetModel.Delete", m.QueryTimeout)
+	defer done()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM snippet_tags WHERE snippet_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM snippet_files WHERE snippet_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM comments WHERE snippet_id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	stmt := `DELETE FROM snippets WHERE id = ?`
+
+	result, err := tx.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoRecord
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,184 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/vishal-rfx/snippetbox/internal/cache"
+)
+
+// latestSnippetsKey is the cache key for the result of Latest() without a tag.
+const latestSnippetsKey = "snippets:latest"
+
+// CacheStats holds the counts of cache hits and misses for a CachedSnippetModel. Errors counts the times the
+// cache couldn't be read or written; the database is used instead when this happens.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	Errors uint64
+}
+
+// CachedSnippetModel wraps a SnippetModelInterface, caching the results of Get() and Latest(). Entries are
+// cached for up to TTL, but never beyond the expiry time of the snippets in them, so an expired snippet is
+// never served from the cache. Insert() and Delete() invalidate the entries they affect.
+//
+// If several instances of the application share a database, they should also share a Cache (or use a short
+// TTL), otherwise a snippet deleted through one instance can still be served from the cache of another until
+// its entry expires.
+type CachedSnippetModel struct {
+	SnippetModelInterface
+	Cache cache.Store
+	TTL   time.Duration
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+	errors atomic.Uint64
+}
+
+// Stats returns the hit and miss counts for the cache.
+func (m *CachedSnippetModel) Stats() CacheStats {
+	return CacheStats{
+		Hits:   m.hits.Load(),
+		Misses: m.misses.Load(),
+		Errors: m.errors.Load(),
+	}
+}
+
+func (m *CachedSnippetModel) Get(ctx context.Context, id int) (Snippet, error) {
+	key := snippetKey(id)
+
+	var s Snippet
+	if m.load(ctx, key, &s) {
+		return s, nil
+	}
+
+	s, err := m.SnippetModelInterface.Get(ctx, id)
+	if err != nil {
+		return Snippet{}, err
+	}
+
+	m.store(ctx, key, s, s.Expires)
+	return s, nil
+}
+
+func (m *CachedSnippetModel) Latest(ctx context.Context, tag string) ([]Snippet, error) {
+	key := latestKey(tag)
+
+	var snippets []Snippet
+	if m.load(ctx, key, &snippets) {
+		return snippets, nil
+	}
+
+	snippets, err := m.SnippetModelInterface.Latest(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	// The list must be refreshed as soon as any snippet in it expires.
+	expires := time.Now().Add(m.TTL)
+	for _, s := range snippets {
+		if s.Expires.Before(expires) {
+			expires = s.Expires
+		}
+	}
+
+	m.store(ctx, key, snippets, expires)
+	return snippets, nil
+}
+
+func (m *CachedSnippetModel) Insert(ctx context.Context, userID int, parentID int, title string, files []SnippetFile, expires int, tags []string) (int, error) {
+	id, err := m.SnippetModelInterface.Insert(ctx, userID, parentID, title, files, expires, tags)
+	if err != nil {
+		return 0, err
+	}
+
+	m.invalidate(ctx, latestKeys(tags)...)
+	return id, nil
+}
+
+func (m *CachedSnippetModel) Delete(ctx context.Context, id int) error {
+	// Look the snippet up first, to find out which of the tag listings it might be in. If it has already
+	// expired it isn't in any of them.
+	s, err := m.Get(ctx, id)
+	if err != nil && !errors.Is(err, ErrNoRecord) {
+		return err
+	}
+
+	err = m.SnippetModelInterface.Delete(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	m.invalidate(ctx, append(latestKeys(s.Tags), snippetKey(id))...)
+	return nil
+}
+
+// load reads the cache entry for key into dst, and reports whether it was found.
+func (m *CachedSnippetModel) load(ctx context.Context, key string, dst any) bool {
+	b, found, err := m.Cache.Get(ctx, key)
+	if err == nil && found {
+		err = json.Unmarshal(b, dst)
+		if err == nil {
+			m.hits.Add(1)
+			return true
+		}
+	}
+
+	if err != nil {
+		m.errors.Add(1)
+	}
+	m.misses.Add(1)
+	return false
+}
+
+// store caches value under key until the earlier of the TTL and expires.
+func (m *CachedSnippetModel) store(ctx context.Context, key string, value any, expires time.Time) {
+	ttl := min(m.TTL, time.Until(expires))
+	if ttl <= 0 {
+		return
+	}
+
+	b, err := json.Marshal(value)
+	if err == nil {
+		err = m.Cache.Set(ctx, key, b, ttl)
+	}
+	if err != nil {
+		m.errors.Add(1)
+	}
+}
+
+// invalidate removes the cache entries for keys. If this fails the entries are left to expire by themselves,
+// as the write to the database has already succeeded.
+func (m *CachedSnippetModel) invalidate(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		err := m.Cache.Delete(ctx, key)
+		if err != nil {
+			m.errors.Add(1)
+		}
+	}
+}
+
+func snippetKey(id int) string {
+	return "snippet:" + strconv.Itoa(id)
+}
+
+// latestKey returns the cache key for the result of Latest() for tag.
+func latestKey(tag string) string {
+	if tag == "" {
+		return latestSnippetsKey
+	}
+	return latestSnippetsKey + ":tag:" + tag
+}
+
+// latestKeys returns the cache keys for all the Latest() results which a snippet with tags appears in.
+func latestKeys(tags []string) []string {
+	keys := []string{latestSnippetsKey}
+	for _, tag := range tags {
+		keys = append(keys, latestKey(tag))
+	}
+	return keys
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+	"github.com/vishal-rfx/snippetbox/internal/cache"
+)
+
+// countingSnippetModel is a stand-in for the database snippet model which counts the calls made to it.
+// Snippet 1 expires in a day and snippet 2 has already expired.
+type countingSnippetModel struct {
+	calls int
+}
+
+func (m *countingSnippetModel) Insert(ctx context.Context, userID int, parentID int, title string, files []SnippetFile, expires int, tags []string) (int, error) {
+	m.calls++
+	return 3, nil
+}
+
+func (m *countingSnippetModel) Get(ctx context.Context, id int) (Snippet, error) {
+	m.calls++
+	switch id {
+	case 1:
+		return Snippet{ID: 1, Title: "Fresh", Expires: time.Now().Add(24 * time.Hour), Tags: []string{"go"}}, nil
+	case 2:
+		return Snippet{ID: 2, Title: "Stale", Expires: time.Now().Add(-time.Second)}, nil
+	default:
+		return Snippet{}, ErrNoRecord
+	}
+}
+
+func (m *countingSnippetModel) UserSnippetIDs(ctx context.Context, userID int) ([]int, error) {
+	m.calls++
+	return []int{1}, nil
+}
+
+func (m *countingSnippetModel) Latest(ctx context.Context, tag string) ([]Snippet, error) {
+	m.calls++
+	return []Snippet{{ID: 1, Title: "Fresh", Expires: time.Now().Add(24 * time.Hour), Tags: []string{"go"}}}, nil
+}
+
+func (m *countingSnippetModel) Forks(ctx context.Context, id int) ([]Snippet, error) {
+	m.calls++
+	return nil, nil
+}
+
+func (m *countingSnippetModel) Tags(ctx context.Context) ([]TagCount, error) {
+	m.calls++
+	return []TagCount{{Name: "go", Count: 1}}, nil
+}
+
+func (m *countingSnippetModel) Delete(ctx context.Context, id int) error {
+	m.calls++
+	return nil
+}
+
+func TestCachedSnippetModel(t *testing.T) {
+	ctx := context.Background()
+	db := &countingSnippetModel{}
+	m := &CachedSnippetModel{SnippetModelInterface: db, Cache: cache.NewMemoryStore(10), TTL: time.Minute}
+
+	// The first read goes to the database, and the second is served from the cache.
+	for i := 0; i < 2; i++ {
+		s, err := m.Get(ctx, 1)
+		assert.NilError(t, err)
+		assert.Equal(t, s.Title, "Fresh")
+	}
+	assert.Equal(t, db.calls, 1)
+	assert.Equal(t, m.Stats(), CacheStats{Hits: 1, Misses: 1})
+
+	// Snippets which have expired are never cached.
+	m.Get(ctx, 2)
+	m.Get(ctx, 2)
+	assert.Equal(t, db.calls, 3)
+
+	// Neither are missing snippets.
+	_, err := m.Get(ctx, 99)
+	assert.Equal(t, err, ErrNoRecord)
+
+	m.Latest(ctx, "")
+	m.Latest(ctx, "")
+	m.Latest(ctx, "go")
+	m.Latest(ctx, "go")
+	m.Latest(ctx, "sql")
+	assert.Equal(t, db.calls, 7)
+
+	// Inserting a snippet invalidates the latest snippets and the listings for its tags, but not the
+	// individual snippets.
+	m.Insert(ctx, 1, 0, "New", []SnippetFile{{Name: "new.txt", Content: "New snippet"}}, 7, []string{"go"})
+	m.Latest(ctx, "")
+	m.Latest(ctx, "go")
+	m.Latest(ctx, "sql")
+	m.Get(ctx, 1)
+	assert.Equal(t, db.calls, 10)
+
+	// Deleting a snippet invalidates the snippet, the latest snippets and the listings for its tags.
+	m.Delete(ctx, 1)
+	m.Latest(ctx, "")
+	m.Latest(ctx, "go")
+	m.Latest(ctx, "sql")
+	m.Get(ctx, 1)
+	assert.Equal(t, db.calls, 14)
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// MaxTags is the most tags a snippet can have, and MaxTagLength is the longest a tag can be.
+const (
+	MaxTags      = 5
+	MaxTagLength = 32
+)
+
+// TagRX matches a normalized tag: lowercase letters, digits, dots, underscores and hyphens, starting with a
+// letter or digit. Tags appear in URLs, so we keep them to characters which don't need escaping.
+var TagRX = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// TagCount holds a tag and the number of (unexpired) snippets which have it.
+type TagCount struct {
+	Name  string
+	Count int
+}
+
+// NormalizeTag returns the canonical form of a tag, so that "Svc-Foo" and "#svc-foo" are the same tag.
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimLeft(strings.TrimSpace(tag), "#"))
+}
+
+// ParseTags splits a list of tags separated by commas or spaces, as they're typed into the snippet form,
+// and normalizes them. Empty and duplicate tags are dropped, and the rest are returned in the order given.
+// The tags aren't validated, so that the form can report the problem to the user.
+func ParseTags(s string) []string {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+
+	var tags []string
+	seen := map[string]bool{}
+	for _, field := range fields {
+		tag := NormalizeTag(field)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	return tags
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{name: "Empty", input: "", want: nil},
+		{name: "Commas", input: "svc-foo,go", want: []string{"svc-foo", "go"}},
+		{name: "Spaces", input: "  svc-foo   go ", want: []string{"svc-foo", "go"}},
+		{name: "Mixed", input: "svc-foo, go,,sql", want: []string{"svc-foo", "go", "sql"}},
+		{name: "Case and hashes", input: "Svc-Foo #GO", want: []string{"svc-foo", "go"}},
+		{name: "Duplicates", input: "go, Go, #go", want: []string{"go"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseTags(tt.input)
+			assert.Equal(t, slices.Equal(got, tt.want), true)
+		})
+	}
+}
@@ -1,2 +1,10 @@
+DROP TABLE comments;
+DROP TABLE snippet_files;
+DROP TABLE snippet_tags;
+DROP TABLE tags;
+DROP TABLE remember_tokens;
+DROP TABLE user_sessions;
+DROP TABLE sessions;
+DROP TABLE user_identities;
 DROP TABLE users;
 DROP TABLE snippets;
\ No newline at end of file
@@ -1,43 +1,84 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
+	"github.com/vishal-rfx/snippetbox/internal/random"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Role is the level of access a user has. Each role includes all the permissions of the roles below it.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// Roles lists every valid role, in increasing order of access.
+var Roles = []Role{RoleUser, RoleModerator, RoleAdmin}
+
+// Allows returns true if the role grants at least the access of the required role.
+func (r Role) Allows(required Role) bool {
+	have, need := slices.Index(Roles, r), slices.Index(Roles, required)
+	return have >= 0 && need >= 0 && have >= need
+}
+
 type User struct {
 	ID int
 	Name string
 	Email string
 	HashedPassword []byte
 	Created time.Time
+	Role Role
+	Disabled bool
+	PasswordResetRequired bool
+	// External is true for users who were created by logging in through an external provider. They have a
+	// random password which nobody knows, so they can't change it.
+	External bool
 }
 
 type UserModelInterface interface {
-	Insert(name, email, password string) error
-	Authenticate(email, password string) (int, error)
-	Exists(id int) (bool, error)
+	Insert(ctx context.Context, name, email, password string) error
+	Authenticate(ctx context.Context, email, password string) (int, error)
+	Exists(ctx context.Context, id int) (bool, error)
+	UpsertExternal(ctx context.Context, provider, subject, name, email string) (int, error)
+	LinkExternal(ctx context.Context, provider, subject string, userID int) error
+	Get(ctx context.Context, id int) (User, error)
+	GetByEmail(ctx context.Context, email string) (User, error)
+	Search(ctx context.Context, query string) ([]User, error)
+	SetRole(ctx context.Context, id int, role Role) error
+	SetDisabled(ctx context.Context, id int, disabled bool) error
+	RequirePasswordReset(ctx context.Context, id int) error
+	PasswordUpdate(ctx context.Context, id int, currentPassword, newPassword string) error
 }
 
 type UserModel struct {
 	DB *sql.DB
+	// QueryTimeout limits how long each method can spend waiting on the database. Zero means no limit.
+	QueryTimeout time.Duration
 }
 
-func (m *UserModel) Insert(name, email, password string) error {
+func (m *UserModel) Insert(ctx context.Context, name, email, password string) error {
+	ctx, done := startQuery(ctx, "UserModel.Insert", m.QueryTimeout)
+	defer done()
+
 	// Create a bcrypt hash of the plain-text password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	hashedPassword, err := hashPassword(ctx, password)
 	if err != nil {
 		return err
 	}
 	stmt := `INSERT INTO users (name, email, hashed_password, created) VALUES(?, ?, ?, UTC_TIMESTAMP())`
 
 	// Use the Exec() method to insert the user details and hashed password into the table
-	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
+	_, err = m.DB.ExecContext(ctx, stmt, name, email, string(hashedPassword))
 	if err != nil {
 		// If this returns an error, we use the errors.As() function to check whether the error has the type
 		// *mysql.MySQLError. If it does, the error will be assigned to the mySQLError variable. We can then
@@ -56,15 +97,19 @@ func (m *UserModel) Insert(name, email, password string) error {
 	return nil
 }
 
-func (m *UserModel) Authenticate(email, password string) (int, error) {
+func (m *UserModel) Authenticate(ctx context.Context, email, password string) (int, error) {
+	ctx, done := startQuery(ctx, "UserModel.Authenticate", m.QueryTimeout)
+	defer done()
+
 	// Retrieve the id and hashed password for the given email. If no matching email exists we return
 	// the ErrInvalidCredentials error.
 	var id int
 	var hashedPassword []byte
+	var disabled bool
 	
-	stmt := "SELECT id, hashed_password FROM users WHERE email = ?"
+	stmt := "SELECT id, hashed_password, disabled FROM users WHERE email = ?"
 
-	err := m.DB.QueryRow(stmt, email).Scan(&id, &hashedPassword)
+	err := m.DB.QueryRowContext(ctx, stmt, email).Scan(&id, &hashedPassword, &disabled)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, ErrInvalidCredentials
@@ -72,7 +117,7 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 		return 0, err
 	}
 
-	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	err = comparePassword(ctx, hashedPassword, password)
 	if err != nil {
 		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword){
 			return 0, ErrInvalidCredentials
@@ -81,14 +126,377 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 		return 0, err
 	}
 
+	// Only tell the user that their account is disabled once they have proved who they are.
+	if disabled {
+		return 0, ErrAccountDisabled
+	}
+
 	return id, nil
 
 }
 
-func (m *UserModel) Exists(id int) (bool, error) {
+func (m *UserModel) Exists(ctx context.Context, id int) (bool, error) {
+	ctx, done := startQuery(ctx, "UserModel.Exists", m.QueryTimeout)
+	defer done()
+
 	var exists bool
 	stmt := `SELECT EXISTS(SELECT true FROM users WHERE id = ?)`
 	
-	err := m.DB.QueryRow(stmt, id).Scan(&exists)
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&exists)
 	return exists, err
 }
+
+// UpsertExternal finds or creates the local user for an identity which has been authenticated by an external
+// provider (like an OpenID Connect issuer), and returns their user ID. The provider and subject uniquely
+// identify the external identity. The caller must only pass an email address which the provider has verified.
+//
+// If the identity has been seen before, the linked user's name and email are updated to match the provider.
+// Otherwise, if an external user exists with the same email address then the identity is linked to them, and
+// if nobody has that email address then a new external user is created. External users have a random
+// password, so they can only log in through an external provider.
+//
+// We never link an identity to a user with a password by their email address, as we don't verify the email
+// addresses of local accounts. Anyone could sign up with somebody else's address, and would then share the
+// account with them when they first logged in through the provider. Instead we return ErrLocalAccount, and
+// the user has to log in with their password and link the identity with LinkExternal.
+func (m *UserModel) UpsertExternal(ctx context.Context, provider, subject, name, email string) (int, error) {
+	ctx, done := startQuery(ctx, "UserModel.UpsertExternal", m.QueryTimeout)
+	defer done()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	stmt := `SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?`
+
+	err = tx.QueryRowContext(ctx, stmt, provider, subject).Scan(&id)
+	switch {
+	case err == nil:
+		// Keep the local user record in sync with the provider.
+		stmt = `UPDATE users SET name = ?, email = ? WHERE id = ?`
+		_, err = tx.ExecContext(ctx, stmt, name, email, id)
+		if err != nil {
+			return 0, duplicateEmailError(err)
+		}
+
+	case errors.Is(err, sql.ErrNoRows):
+		var external bool
+		stmt = `SELECT id, external FROM users WHERE email = ?`
+		err = tx.QueryRowContext(ctx, stmt, email).Scan(&id, &external)
+		if errors.Is(err, sql.ErrNoRows) {
+			id, err = insertExternalUser(ctx, tx, name, email)
+		} else if err == nil && !external {
+			return 0, ErrLocalAccount
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		stmt = `INSERT INTO user_identities (provider, subject, user_id, created) VALUES (?, ?, ?, UTC_TIMESTAMP())`
+		_, err = tx.ExecContext(ctx, stmt, provider, subject, id)
+		if err != nil {
+			return 0, err
+		}
+
+	default:
+		return 0, err
+	}
+
+	var disabled bool
+	err = tx.QueryRowContext(ctx, `SELECT disabled FROM users WHERE id = ?`, id).Scan(&disabled)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	if disabled {
+		return 0, ErrAccountDisabled
+	}
+
+	return id, nil
+}
+
+// LinkExternal links an identity which has been authenticated by an external provider to an existing user,
+// so that they can log in through the provider too. The caller must have authenticated the user themselves,
+// for example with their password. If the identity is already linked to a different user we return
+// ErrIdentityInUse.
+func (m *UserModel) LinkExternal(ctx context.Context, provider, subject string, userID int) error {
+	ctx, done := startQuery(ctx, "UserModel.LinkExternal", m.QueryTimeout)
+	defer done()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var linkedID int
+	stmt := `SELECT user_id FROM user_identities WHERE provider = ? AND subject = ? FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, stmt, provider, subject).Scan(&linkedID)
+	switch {
+	case err == nil && linkedID == userID:
+		return nil
+	case err == nil:
+		return ErrIdentityInUse
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+
+	stmt = `INSERT INTO user_identities (provider, subject, user_id, created) VALUES (?, ?, ?, UTC_TIMESTAMP())`
+	_, err = tx.ExecContext(ctx, stmt, provider, subject, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertExternalUser creates a user with a random, unknown password, and marks them as external.
+func insertExternalUser(ctx context.Context, tx *sql.Tx, name, email string) (int, error) {
+	password, err := random.Token()
+	if err != nil {
+		return 0, err
+	}
+
+	hashedPassword, err := hashPassword(ctx, password)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `INSERT INTO users (name, email, hashed_password, created, external) VALUES(?, ?, ?, UTC_TIMESTAMP(), true)`
+	result, err := tx.ExecContext(ctx, stmt, name, email, string(hashedPassword))
+	if err != nil {
+		return 0, duplicateEmailError(err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// duplicateEmailError converts a MySQL duplicate entry error on the users_uc_email key into ErrDuplicateEmail,
+// and returns any other error unchanged.
+func duplicateEmailError(err error) error {
+	var mySQLError *mysql.MySQLError
+	if errors.As(err, &mySQLError) {
+		if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
+			return ErrDuplicateEmail
+		}
+	}
+
+	return err
+}
+
+// Get returns the details of a specific user, excluding their password hash.
+func (m *UserModel) Get(ctx context.Context, id int) (User, error) {
+	ctx, done := startQuery(ctx, "UserModel.Get", m.QueryTimeout)
+	defer done()
+
+	stmt := `SELECT id, name, email, created, role, disabled, password_reset_required, external
+			 FROM users
+			 WHERE id = ?`
+
+	var u User
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Role, &u.Disabled, &u.PasswordResetRequired, &u.External)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, ErrNoRecord
+		}
+		return User{}, err
+	}
+
+	return u, nil
+}
+
+// GetByEmail returns the details of the user with the given email address, excluding their password hash.
+func (m *UserModel) GetByEmail(ctx context.Context, email string) (User, error) {
+	ctx, done := startQuery(ctx, "UserModel.GetByEmail", m.QueryTimeout)
+	defer done()
+
+	stmt := `SELECT id, name, email, created, role, disabled, password_reset_required, external
+			 FROM users
+			 WHERE email = ?`
+
+	var u User
+	err := m.DB.QueryRowContext(ctx, stmt, email).Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Role, &u.Disabled, &u.PasswordResetRequired, &u.External)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, ErrNoRecord
+		}
+		return User{}, err
+	}
+
+	return u, nil
+}
+
+// Search returns up to 50 users whose name or email address contains the query, or the 50 most recently
+// created users if the query is empty.
+func (m *UserModel) Search(ctx context.Context, query string) ([]User, error) {
+	ctx, done := startQuery(ctx, "UserModel.Search", m.QueryTimeout)
+	defer done()
+
+	stmt := `
+		SELECT id, name, email, created, role, disabled, password_reset_required, external
+		FROM users
+		WHERE name LIKE ? OR email LIKE ?
+		ORDER BY id DESC
+		LIMIT 50
+	`
+
+	// Escape any LIKE wildcards in the query, so that they are matched literally.
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+
+	rows, err := m.DB.QueryContext(ctx, stmt, pattern, pattern)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		err = rows.Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Role, &u.Disabled, &u.PasswordResetRequired, &u.External)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// SetRole changes the role of a user.
+func (m *UserModel) SetRole(ctx context.Context, id int, role Role) error {
+	ctx, done := startQuery(ctx, "UserModel.SetRole", m.QueryTimeout)
+	defer done()
+
+	stmt := `UPDATE users SET role = ? WHERE id = ?`
+	return m.execForUser(ctx, stmt, role, id)
+}
+
+// SetDisabled disables or re-enables a user account. Disabled users can't log in.
+func (m *UserModel) SetDisabled(ctx context.Context, id int, disabled bool) error {
+	ctx, done := startQuery(ctx, "UserModel.SetDisabled", m.QueryTimeout)
+	defer done()
+
+	stmt := `UPDATE users SET disabled = ? WHERE id = ?`
+	return m.execForUser(ctx, stmt, disabled, id)
+}
+
+// RequirePasswordReset forces a user to change their password the next time they use the site. External users
+// don't know their password, so they would be locked out, and we return ErrExternalUser for them instead.
+func (m *UserModel) RequirePasswordReset(ctx context.Context, id int) error {
+	ctx, done := startQuery(ctx, "UserModel.RequirePasswordReset", m.QueryTimeout)
+	defer done()
+
+	var external bool
+	err := m.DB.QueryRowContext(ctx, `SELECT external FROM users WHERE id = ?`, id).Scan(&external)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	if external {
+		return ErrExternalUser
+	}
+
+	stmt := `UPDATE users SET password_reset_required = true WHERE id = ?`
+	return m.execForUser(ctx, stmt, id)
+}
+
+// PasswordUpdate checks the user's current password and replaces it with a new one, clearing any forced
+// password reset. If the current password is wrong we return ErrInvalidCredentials.
+func (m *UserModel) PasswordUpdate(ctx context.Context, id int, currentPassword, newPassword string) error {
+	ctx, done := startQuery(ctx, "UserModel.PasswordUpdate", m.QueryTimeout)
+	defer done()
+
+	var currentHashedPassword []byte
+
+	stmt := `SELECT hashed_password FROM users WHERE id = ?`
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&currentHashedPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	err = comparePassword(ctx, currentHashedPassword, currentPassword)
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrInvalidCredentials
+		}
+		return err
+	}
+
+	newHashedPassword, err := hashPassword(ctx, newPassword)
+	if err != nil {
+		return err
+	}
+
+	stmt = `UPDATE users SET hashed_password = ?, password_reset_required = false WHERE id = ?`
+	return m.execForUser(ctx, stmt, string(newHashedPassword), id)
+}
+
+// execForUser executes an UPDATE statement for a single user, whose ID must be the last argument, and
+// returns ErrNoRecord if no such user exists.
+func (m *UserModel) execForUser(ctx context.Context, stmt string, args ...any) error {
+	result, err := m.DB.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// MySQL reports the number of rows actually changed rather than matched, so an update which
+	// doesn't change anything looks the same as a missing user. Check for that case explicitly.
+	if rows == 0 {
+		exists, err := m.Exists(ctx, args[len(args)-1].(int))
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNoRecord
+		}
+	}
+
+	return nil
+}
+
+// hashPassword and comparePassword wrap the bcrypt functions in their own spans. bcrypt is deliberately slow,
+// so it is often the biggest part of a login or signup request and it's useful to see it separately from
+// the queries.
+func hashPassword(ctx context.Context, password string) ([]byte, error) {
+	_, span := tracer.Start(ctx, "bcrypt.GenerateFromPassword")
+	defer span.End()
+
+	return bcrypt.GenerateFromPassword([]byte(password), 12)
+}
+
+func comparePassword(ctx context.Context, hashedPassword []byte, password string) error {
+	_, span := tracer.Start(ctx, "bcrypt.CompareHashAndPassword")
+	defer span.End()
+
+	return bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+}
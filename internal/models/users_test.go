@@ -1,6 +1,8 @@
 package models
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/vishal-rfx/snippetbox/internal/assert"
@@ -36,11 +38,107 @@ func TestUserModelExits(t *testing.T) {
             // means that fresh database tables and data will be set up and torn down for each sub test
             db := newTestDB(t)
             // Create a new instance of the UserModel
-            m := UserModel{db}
+            m := UserModel{DB: db}
 
-            exists, err := m.Exists(tt.userID)
+            exists, err := m.Exists(context.Background(), tt.userID)
             assert.Equal(t, exists, tt.want)
             assert.NilError(t, err)
         })
     }
-}
\ No newline at end of file
+}
+
+func TestUserModelGetByEmail(t *testing.T) {
+    db := newTestDB(t)
+    m := UserModel{DB: db}
+
+    user, err := m.GetByEmail(context.Background(), "alice@example.com")
+    assert.NilError(t, err)
+    assert.Equal(t, user.ID, 1)
+    assert.Equal(t, user.Name, "Alice Jones")
+
+    _, err = m.GetByEmail(context.Background(), "nobody@example.com")
+    assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+}
+
+func TestUserModelRequirePasswordReset(t *testing.T) {
+    db := newTestDB(t)
+    m := UserModel{DB: db}
+    ctx := context.Background()
+
+    err := m.RequirePasswordReset(ctx, 1)
+    assert.NilError(t, err)
+
+    user, err := m.Get(ctx, 1)
+    assert.NilError(t, err)
+    assert.Equal(t, user.PasswordResetRequired, true)
+
+    // Users created through an external provider don't know their password, so they can't be made to change it.
+    id, err := m.UpsertExternal(ctx, "oidc", "bob", "Bob", "bob@example.com")
+    assert.NilError(t, err)
+
+    err = m.RequirePasswordReset(ctx, id)
+    assert.Equal(t, errors.Is(err, ErrExternalUser), true)
+
+    user, err = m.Get(ctx, id)
+    assert.NilError(t, err)
+    assert.Equal(t, user.External, true)
+    assert.Equal(t, user.PasswordResetRequired, false)
+
+    err = m.RequirePasswordReset(ctx, 99)
+    assert.Equal(t, errors.Is(err, ErrNoRecord), true)
+}
+
+func TestUserModelUpsertExternal(t *testing.T) {
+    db := newTestDB(t)
+    m := UserModel{DB: db}
+    ctx := context.Background()
+
+    // Alice has a password, and we never checked that she owns her email address, so a provider identity
+    // with the same address mustn't be linked to her account.
+    _, err := m.UpsertExternal(ctx, "oidc", "alice", "Alice Jones", "alice@example.com")
+    assert.Equal(t, errors.Is(err, ErrLocalAccount), true)
+
+    // Once she has linked the identity herself, it logs her in.
+    err = m.LinkExternal(ctx, "oidc", "alice", 1)
+    assert.NilError(t, err)
+
+    id, err := m.UpsertExternal(ctx, "oidc", "alice", "Alice Jones", "alice@example.com")
+    assert.NilError(t, err)
+    assert.Equal(t, id, 1)
+
+    // A new email address gets a new external user, which another provider can be linked to by email.
+    bobID, err := m.UpsertExternal(ctx, "oidc", "bob", "Bob", "bob@example.com")
+    assert.NilError(t, err)
+
+    id, err = m.UpsertExternal(ctx, "ldap", "uid=bob", "Bob", "bob@example.com")
+    assert.NilError(t, err)
+    assert.Equal(t, id, bobID)
+
+    // An identity can only be linked to one user.
+    err = m.LinkExternal(ctx, "oidc", "bob", 1)
+    assert.Equal(t, errors.Is(err, ErrIdentityInUse), true)
+
+    err = m.LinkExternal(ctx, "oidc", "bob", bobID)
+    assert.NilError(t, err)
+}
+
+func TestRoleAllows(t *testing.T) {
+    tests := []struct {
+        name string
+        role Role
+        required Role
+        want bool
+    }{
+        {name: "Same role", role: RoleModerator, required: RoleModerator, want: true},
+        {name: "Higher role", role: RoleAdmin, required: RoleModerator, want: true},
+        {name: "Lower role", role: RoleUser, required: RoleAdmin, want: false},
+        {name: "Empty role", role: "", required: RoleUser, want: false},
+        {name: "Unknown required role", role: RoleAdmin, required: "superuser", want: false},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            assert.Equal(t, tt.role.Allows(tt.required), tt.want)
+        })
+    }
+}
@@ -0,0 +1,18 @@
+// Package random generates unguessable tokens for things like session secrets and OAuth state values.
+package random
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// Token returns 32 bytes from the operating system's CSPRNG, encoded as unpadded URL-safe base64.
+func Token() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,98 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Policy describes a token bucket which allows up to Limit requests in a burst, refilled at a rate of Limit
+// tokens every Period. For example, Policy{Limit: 10, Period: time.Minute} allows a burst of 10 requests,
+// and then one more request every 6 seconds.
+type Policy struct {
+	Limit  int
+	Period time.Duration
+}
+
+// rate returns the number of tokens added to the bucket per second.
+func (p Policy) rate() float64 {
+	return float64(p.Limit) / p.Period.Seconds()
+}
+
+// Store keeps track of the token buckets for each key. The in-memory store is only suitable for a single
+// instance of the application; when running several instances behind a load balancer, implement Store on
+// top of a shared database (like Redis or MySQL) so that all instances enforce the same limits.
+type Store interface {
+	// Allow takes a token from the bucket for key. If the bucket is empty it returns false, along with how
+	// long the client should wait until a token will be available.
+	Allow(key string, policy Policy) (ok bool, retryAfter time.Duration, err error)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// MemoryStore is an in-memory Store.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	// now is used in place of time.Now, so that tests can control the clock.
+	now func() time.Time
+}
+
+// sweepInterval is how often idle buckets are removed from a MemoryStore.
+const sweepInterval = time.Minute
+
+// NewMemoryStore returns a new, empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow implements Store.
+func (s *MemoryStore) Allow(key string, policy Policy) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(policy.Limit), last: now}
+		s.buckets[key] = b
+	}
+
+	// Refill the bucket for the time that has passed since it was last used, up to the limit.
+	b.tokens = math.Min(float64(policy.Limit), b.tokens+now.Sub(b.last).Seconds()*policy.rate())
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / policy.rate()
+		return false, time.Duration(wait * float64(time.Second)), nil
+	}
+
+	b.tokens--
+	return true, 0, nil
+}
+
+// sweep removes buckets which haven't been used for a while. A bucket which has been idle for longer than
+// its policy's period is full again, so forgetting it doesn't change anything. As we don't know which policy
+// each bucket belongs to, we keep them for an hour, which is longer than any period we use.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+
+	for key, b := range s.buckets {
+		if now.Sub(b.last) > time.Hour {
+			delete(s.buckets, key)
+		}
+	}
+
+	s.lastSweep = now
+}
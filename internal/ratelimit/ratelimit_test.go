@@ -0,0 +1,49 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vishal-rfx/snippetbox/internal/assert"
+)
+
+func TestMemoryStoreAllow(t *testing.T) {
+	now := time.Date(2024, 12, 12, 10, 15, 0, 0, time.UTC)
+
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+
+	policy := Policy{Limit: 3, Period: 30 * time.Second}
+
+	// The bucket starts full, so a burst of up to the limit is allowed.
+	for i := 0; i < 3; i++ {
+		ok, _, err := s.Allow("client", policy)
+		assert.NilError(t, err)
+		assert.Equal(t, ok, true)
+	}
+
+	ok, retryAfter, err := s.Allow("client", policy)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+	assert.Equal(t, retryAfter, 10*time.Second)
+
+	// Other keys have their own bucket.
+	ok, _, err = s.Allow("other-client", policy)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+
+	// One token is added every 10 seconds.
+	now = now.Add(10 * time.Second)
+	ok, _, err = s.Allow("client", policy)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+
+	ok, _, err = s.Allow("client", policy)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+
+	// Idle buckets are swept away.
+	now = now.Add(2 * time.Hour)
+	s.Allow("client", policy)
+	assert.Equal(t, len(s.buckets), 1)
+}
@@ -75,4 +75,16 @@ func MaxChars(value string, n int) bool {
 // PermittedValue() returns true if a value is in a list of specific permitted values
 func PermittedValue[T comparable](value T, permittedValues ...T) bool {
 	return slices.Contains(permittedValues, value)
-}
\ No newline at end of file
+}
+
+// Unique() returns true if none of the values are repeated
+func Unique[T comparable](values []T) bool {
+	seen := make(map[T]bool, len(values))
+	for _, value := range values {
+		if seen[value] {
+			return false
+		}
+		seen[value] = true
+	}
+	return true
+}
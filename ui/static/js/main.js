@@ -5,4 +5,78 @@ for (var i = 0; i < navLinks.length; i++) {
 		link.classList.add("live");
 		break;
 	}
-}
\ No newline at end of file
+}
+
+// Highlight the lines in the URL fragment, like #L10-L20 on the page for a single file, or #main.go-L10-L20
+// on the page for a whole snippet. The line number links choose a line, and shift-clicking another line
+// number chooses the range of lines between them, which is also filled in on the comment form.
+var lineFragmentRX = /^#(.*?L)(\d+)(?:-L(\d+))?$/;
+var lastLine = null;
+
+function highlightLines() {
+	var highlighted = document.querySelectorAll("table.lines tr.highlighted");
+	for (var i = 0; i < highlighted.length; i++) {
+		highlighted[i].classList.remove("highlighted");
+	}
+
+	// File names in the fragment are percent-encoded. Anybody can write a link with a fragment that isn't
+	// valid, like #%E0, which we ignore.
+	var hash;
+	try {
+		hash = decodeURIComponent(window.location.hash);
+	} catch (e) {
+		return null;
+	}
+
+	var match = lineFragmentRX.exec(hash);
+	if (!match) {
+		return null;
+	}
+	var start = parseInt(match[2], 10);
+	var end = match[3] ? parseInt(match[3], 10) : start;
+
+	var first = null;
+	for (var n = Math.min(start, end); n <= Math.max(start, end); n++) {
+		var row = document.getElementById(match[1] + n);
+		if (!row) {
+			break;
+		}
+		row.classList.add("highlighted");
+		first = first || row;
+	}
+	return first;
+}
+
+var firstLine = highlightLines();
+if (firstLine) {
+	firstLine.scrollIntoView();
+}
+window.addEventListener("hashchange", highlightLines);
+
+document.addEventListener("click", function (e) {
+	var link = e.target.closest("td.line-number a");
+	if (!link) {
+		return;
+	}
+	var table = link.closest("table.lines");
+	var anchor = table.dataset.anchor;
+	var n = parseInt(link.closest("tr").id.slice(anchor.length), 10);
+
+	var start = n, end = n;
+	if (e.shiftKey && lastLine && lastLine.table === table) {
+		start = Math.min(lastLine.n, n);
+		end = Math.max(lastLine.n, n);
+	} else {
+		lastLine = {table: table, n: n};
+	}
+
+	e.preventDefault();
+	history.replaceState(null, "", "#" + anchor + start + (end > start ? "-L" + end : ""));
+	highlightLines();
+
+	var form = document.getElementById("comment-form");
+	if (form && form.elements.lines) {
+		form.elements.file.value = table.dataset.file;
+		form.elements.lines.value = end > start ? start + "-" + end : start;
+	}
+});